@@ -0,0 +1,43 @@
+package gathersrv
+
+import "github.com/miekg/dns"
+
+// bailiwickOf returns zones which records returned by the cluster for the question have to belong to:
+// the reverse zones of the cluster for PTR questions, otherwise the cluster domain
+func bailiwickOf(cluster Cluster, question dns.Question) []string {
+	if question.Qtype == dns.TypePTR {
+		return cluster.ReverseZones
+	}
+	return []string{cluster.Suffix}
+}
+
+// enforceBailiwick returns a copy of the sub-response without answer and additional records outside the bailiwick of the sub-request
+// together with the number of dropped records
+func (s *subRequest) enforceBailiwick(response *dns.Msg) (*dns.Msg, int) {
+	msg := response.Copy()
+	var dropped int
+	msg.Answer, dropped = s.withinBailiwick(msg.Answer, dropped)
+	msg.Extra, dropped = s.withinBailiwick(msg.Extra, dropped)
+	return msg, dropped
+}
+
+func (s *subRequest) withinBailiwick(records []dns.RR, dropped int) ([]dns.RR, int) {
+	kept := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeOPT || s.inBailiwick(rr.Header().Name) {
+			kept = append(kept, rr)
+			continue
+		}
+		dropped++
+	}
+	return kept, dropped
+}
+
+func (s *subRequest) inBailiwick(name string) bool {
+	for _, zone := range s.bailiwick {
+		if dns.IsSubDomain(dns.CanonicalName(zone), dns.CanonicalName(name)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func PrepareInjectingNextHandler(assertion Assertion) test.Handler {
+	// cluster-a returns records of another cluster and of a foreign domain besides its own ones
+	return PrepareContentNextHandler(
+		map[string]Assertion{"demo.svc.cluster-a.local.": assertion, "demo.svc.cluster-b.local.": assertion},
+		map[string][]dns.RR{
+			"demo.svc.cluster-a.local.": {
+				test.A("demo.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+				test.A("demo.svc.cluster-b.local. 30 IN A 10.6.6.6"),
+				test.A("bank.example.com. 30 IN A 10.6.6.7"),
+			},
+			"demo.svc.cluster-b.local.": {test.A("demo.svc.cluster-b.local. 30 IN A 10.9.1.2")},
+		},
+		map[string][]dns.RR{
+			"demo.svc.cluster-a.local.": {test.A("mail.example.com. 30 IN A 10.6.6.8")},
+		},
+	)
+}
+
+func TestShouldDropRecordsOutsideBailiwickOfCluster(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+	}
+	gatherPlugin := &GatherSrv{
+		Next:   PrepareInjectingNextHandler(assertion),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		StrictBailiwick: true,
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.ElementsMatch(
+		t,
+		[]string{
+			"a-demo.svc.distro.local.\t30\tIN\tA\t10.8.1.2",
+			"b-demo.svc.distro.local.\t30\tIN\tA\t10.9.1.2",
+		},
+		RecordsAsStrings(msg.Answer),
+	)
+	require.Empty(t, msg.Extra)
+}
+
+func TestShouldMergeRecordsOutsideBailiwickByDefault(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+	}
+	gatherPlugin := &GatherSrv{
+		Next:   PrepareInjectingNextHandler(assertion),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 4)
+	require.Len(t, msg.Extra, 1)
+}
+
+func TestShouldAcceptReverseRecordsWithinClusterZones(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "2.1.8.10.in-addr.arpa.",
+		GivenType:     dns.TypePTR,
+		ExpectedRcode: dns.RcodeSuccess,
+	}
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{"2.1.8.10.in-addr.arpa.": assertion},
+			map[string][]dns.RR{
+				"2.1.8.10.in-addr.arpa.": {
+					test.PTR("2.1.8.10.in-addr.arpa. 30 IN PTR demo-0.default.svc.cluster-a.local."),
+					test.PTR("2.1.9.10.in-addr.arpa. 30 IN PTR demo-0.default.svc.cluster-b.local."),
+				},
+			},
+			map[string][]dns.RR{},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", ReverseZones: []string{"8.10.in-addr.arpa."}},
+		},
+		StrictBailiwick: true,
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(
+		t,
+		[]string{"2.1.8.10.in-addr.arpa.\t30\tIN\tPTR\ta-demo-0.default.svc.distro.local."},
+		RecordsAsStrings(msg.Answer),
+	)
+}
@@ -0,0 +1,155 @@
+package gathersrv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	breakerWindowSize       = 20
+	breakerWindowMinSamples = 10
+	defaultBreakerFailures  = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// CircuitBreaker stops sending sub-requests to a failing cluster.
+// It opens after the given number of consecutive failures or when the error rate of recent sub-requests exceeds the threshold.
+// After the cooldown a single probe sub-request is allowed (half-open state) - its result decides whether the breaker closes.
+type CircuitBreaker struct {
+	failures int
+	rate     float64
+	cooldown time.Duration
+
+	lock        sync.Mutex
+	state       breakerState
+	consecutive int
+	outcomes    []bool
+	next        int
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreaker returns CircuitBreaker, rate equal to zero disables error rate tracking.
+func NewCircuitBreaker(failures int, rate float64, cooldown time.Duration) (*CircuitBreaker, error) {
+	if failures < 1 {
+		return nil, fmt.Errorf("incorrect number of breaker failures <%d>", failures)
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("incorrect breaker error rate <%v>", rate)
+	}
+	if cooldown <= 0 {
+		return nil, fmt.Errorf("incorrect breaker cooldown <%s>", cooldown)
+	}
+	return &CircuitBreaker{failures: failures, rate: rate, cooldown: cooldown}, nil
+}
+
+// Clone returns CircuitBreaker with the same settings but with its own state.
+func (cb *CircuitBreaker) Clone() *CircuitBreaker {
+	return &CircuitBreaker{failures: cb.failures, rate: cb.rate, cooldown: cb.cooldown}
+}
+
+// Allow reports whether a sub-request could be sent to the cluster.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		return true
+	case breakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record registers the result of a sub-request sent to the cluster.
+func (cb *CircuitBreaker) Record(success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerHalfOpen:
+		cb.probing = false
+		if success {
+			cb.reset(breakerClosed)
+		} else {
+			cb.reset(breakerOpen)
+		}
+	case breakerClosed:
+		if success {
+			cb.consecutive = 0
+		} else {
+			cb.consecutive++
+		}
+		cb.observe(success)
+		if cb.consecutive >= cb.failures || cb.rateExceeded() {
+			cb.reset(breakerOpen)
+		}
+	}
+}
+
+// Abandon releases the probe of the half-open breaker whose result is unknown, e.g. the request has been canceled,
+// so the next sub-request probes the cluster instead.
+func (cb *CircuitBreaker) Abandon() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+	}
+}
+
+// State returns the current state of the breaker: 0 - closed, 1 - half-open, 2 - open.
+func (cb *CircuitBreaker) State() breakerState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) observe(success bool) {
+	if len(cb.outcomes) < breakerWindowSize {
+		cb.outcomes = append(cb.outcomes, success)
+		return
+	}
+	cb.outcomes[cb.next] = success
+	cb.next = (cb.next + 1) % len(cb.outcomes)
+}
+
+func (cb *CircuitBreaker) rateExceeded() bool {
+	if cb.rate == 0 || len(cb.outcomes) < breakerWindowMinSamples {
+		return false
+	}
+	failed := 0
+	for _, success := range cb.outcomes {
+		if !success {
+			failed++
+		}
+	}
+	return float64(failed)/float64(len(cb.outcomes)) >= cb.rate
+}
+
+func (cb *CircuitBreaker) reset(state breakerState) {
+	cb.state = state
+	cb.consecutive = 0
+	cb.outcomes = nil
+	cb.next = 0
+	if state == breakerOpen {
+		cb.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,132 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestShouldOpenBreakerAfterConsecutiveFailures(t *testing.T) {
+	breaker, err := NewCircuitBreaker(2, 0, time.Hour)
+	require.NoError(t, err)
+
+	breaker.Record(false)
+	breaker.Record(true)
+	breaker.Record(false)
+	require.Equal(t, breakerClosed, breaker.State())
+	require.True(t, breaker.Allow())
+
+	breaker.Record(false)
+	require.Equal(t, breakerOpen, breaker.State())
+	require.False(t, breaker.Allow())
+}
+
+func TestShouldOpenBreakerIfErrorRateIsExceeded(t *testing.T) {
+	breaker, err := NewCircuitBreaker(100, 0.5, time.Hour)
+	require.NoError(t, err)
+
+	for i := 0; i < breakerWindowMinSamples/2; i++ {
+		breaker.Record(true)
+		require.Equal(t, breakerClosed, breaker.State())
+		breaker.Record(false)
+	}
+	require.Equal(t, breakerOpen, breaker.State())
+}
+
+func TestShouldProbeClusterAfterCooldown(t *testing.T) {
+	breaker, err := NewCircuitBreaker(1, 0, 10*time.Millisecond)
+	require.NoError(t, err)
+	breaker.Record(false)
+	require.False(t, breaker.Allow())
+
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, breaker.Allow(), "Expected a single probe after cooldown")
+	require.Equal(t, breakerHalfOpen, breaker.State())
+	require.False(t, breaker.Allow(), "Expected no more requests while probing")
+
+	breaker.Record(false)
+	require.Equal(t, breakerOpen, breaker.State())
+	require.False(t, breaker.Allow())
+
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, breaker.Allow())
+	breaker.Record(true)
+	require.Equal(t, breakerClosed, breaker.State())
+	require.True(t, breaker.Allow())
+}
+
+func TestShouldSkipClustersWithOpenBreaker(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	expectedQuestions := map[string]Assertion{
+		"_http._tcp.demo.svc.cluster-a.local.": assertion,
+		"_http._tcp.demo.svc.cluster-b.local.": assertion,
+	}
+	answersFromCluster := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}
+	next := PrepareDelayedNextHandler(
+		PrepareContentNextHandler(expectedQuestions, answersFromCluster, map[string][]dns.RR{}),
+		map[string]time.Duration{"_http._tcp.demo.svc.cluster-b.local.": time.Second},
+	)
+	breaker, _ := NewCircuitBreaker(1, 0, time.Hour)
+	gatherPlugin := &GatherSrv{
+		Next:    next,
+		Domain:  "distro.local.",
+		Timeout: 50 * time.Millisecond,
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-", Breaker: breaker},
+		},
+	}
+
+	// the first request times out on cluster b and opens its breaker
+	CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(t, breakerOpen, breaker.State())
+
+	start := time.Now()
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Less(t, time.Since(start), 25*time.Millisecond)
+	require.Equal(
+		t,
+		[]dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		msg.Answer,
+	)
+}
+
+func TestShouldReleaseProbeCanceledByRequestContext(t *testing.T) {
+	breaker, _ := NewCircuitBreaker(1, 0, 10*time.Millisecond)
+	breaker.Record(false)
+	time.Sleep(20 * time.Millisecond)
+	gatherPlugin := &GatherSrv{
+		Next: test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			<-ctx.Done()
+			return dns.RcodeServerFailure, ctx.Err()
+		}),
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-", Breaker: breaker}},
+	}
+
+	// the probe is cut off by the request context, e.g. by the cancel plugin
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, _ = gatherPlugin.ServeDNS(ctx, rec, new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA))
+
+	require.Eventually(t, func() bool {
+		return breaker.State() == breakerHalfOpen && breaker.Allow()
+	}, time.Second, 5*time.Millisecond, "Expected the abandoned probe to be released")
+}
@@ -0,0 +1,110 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/miekg/dns"
+	"time"
+)
+
+const (
+	defaultCacheMaxTTL      = 3600
+	defaultCacheNegativeTTL = 30
+	defaultCacheCapacity    = 10000
+)
+
+// ResponseCache keeps masqueraded contributions of clusters to merged responses.
+// Each contribution expires on its own (after the minimum TTL of its records), so a single cluster
+// could be refreshed while contributions of the remaining clusters are still served from the cache.
+type ResponseCache struct {
+	maxTTL      uint32
+	negativeTTL uint32
+	entries     *cache.Cache
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// NewResponseCache returns ResponseCache which caps TTL of positive contributions to maxTTL
+// and TTL of negative ones (NXDOMAIN, NODATA) to negativeTTL.
+func NewResponseCache(maxTTL, negativeTTL uint32) (*ResponseCache, error) {
+	if maxTTL == 0 {
+		return nil, fmt.Errorf("incorrect cache max ttl <%d>", maxTTL)
+	}
+	return &ResponseCache{maxTTL: maxTTL, negativeTTL: negativeTTL, entries: cache.New(defaultCacheCapacity)}, nil
+}
+
+// Store caches masqueraded contribution of the cluster with given prefix, failed responses are not cached.
+func (rc *ResponseCache) Store(question dns.Question, prefix string, res *dns.Msg) {
+	ttl, ok := rc.ttl(res)
+	if !ok || ttl == 0 {
+		return
+	}
+	now := time.Now()
+	rc.entries.Add(contributionKey(question, prefix), &cacheEntry{
+		msg:     res.Copy(),
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+}
+
+// Lookup returns a copy of the cached contribution of the cluster with given prefix with TTLs decreased by its age.
+func (rc *ResponseCache) Lookup(question dns.Question, prefix string) (*dns.Msg, bool) {
+	value, ok := rc.entries.Get(contributionKey(question, prefix))
+	if !ok {
+		return nil, false
+	}
+	entry := value.(*cacheEntry)
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		rc.entries.Remove(contributionKey(question, prefix))
+		return nil, false
+	}
+	age := uint32(now.Sub(entry.stored).Seconds())
+	msg := entry.msg.Copy()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > age {
+				rr.Header().Ttl -= age
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	return msg, true
+}
+
+// ttl returns how long the contribution could be cached
+func (rc *ResponseCache) ttl(res *dns.Msg) (uint32, bool) {
+	switch {
+	case res.Rcode == dns.RcodeSuccess && len(res.Answer) > 0:
+		return minTTL(rc.maxTTL, res.Answer, res.Extra), true
+	case res.Rcode == dns.RcodeSuccess || res.Rcode == dns.RcodeNameError:
+		return minTTL(rc.negativeTTL, res.Ns), true
+	default:
+		return 0, false
+	}
+}
+
+// minTTL returns the minimum TTL of records (SOA minimum is taken into account) capped by limit
+func minTTL(limit uint32, sections ...[]dns.RR) uint32 {
+	ttl := limit
+	for _, section := range sections {
+		for _, rr := range section {
+			switch record := rr.(type) {
+			case *dns.OPT:
+				continue
+			case *dns.SOA:
+				ttl = min(ttl, record.Minttl)
+			}
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+	return ttl
+}
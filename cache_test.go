@@ -0,0 +1,121 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShouldCacheContributionsWithMinimalTTL(t *testing.T) {
+	responseCache, err := NewResponseCache(3600, 10)
+	require.NoError(t, err)
+	question := dns.Question{Name: "_http._tcp.demo.svc.distro.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+	positive := &dns.Msg{
+		Answer: []dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		Extra:  []dns.RR{test.A("a-demo-0.svc.distro.local. 20 IN A 10.8.1.2")},
+	}
+	negative := &dns.Msg{
+		MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError},
+		Ns:     []dns.RR{test.SOA("distro.local. 60 IN SOA ns.distro.local. admin.distro.local. 1 7200 1800 86400 5")},
+	}
+	failure := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}
+
+	require.Equal(t, uint32(20), minTTL(responseCache.maxTTL, positive.Answer, positive.Extra))
+	ttl, ok := responseCache.ttl(negative)
+	require.True(t, ok)
+	require.Equal(t, uint32(5), ttl)
+	_, ok = responseCache.ttl(failure)
+	require.False(t, ok)
+
+	responseCache.Store(question, "a-", positive)
+	responseCache.Store(question, "b-", failure)
+
+	cached, ok := responseCache.Lookup(question, "a-")
+	require.True(t, ok)
+	require.Equal(t, positive.Answer, cached.Answer)
+	require.NotSame(t, positive.Answer[0], cached.Answer[0])
+	_, ok = responseCache.Lookup(question, "b-")
+	require.False(t, ok)
+}
+
+func TestShouldDecreaseTTLOfCachedContributions(t *testing.T) {
+	responseCache, _ := NewResponseCache(3600, 10)
+	question := dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	responseCache.Store(question, "a-", &dns.Msg{Answer: []dns.RR{test.A("a-demo.svc.distro.local. 30 IN A 10.8.1.2")}})
+	responseCache.entries.Add(contributionKey(question, "b-"), &cacheEntry{
+		msg:     &dns.Msg{Answer: []dns.RR{test.A("b-demo.svc.distro.local. 30 IN A 10.9.1.2")}},
+		stored:  time.Now().Add(-10 * time.Second),
+		expires: time.Now().Add(20 * time.Second),
+	})
+	responseCache.entries.Add(contributionKey(question, "c-"), &cacheEntry{
+		msg:     &dns.Msg{Answer: []dns.RR{test.A("c-demo.svc.distro.local. 30 IN A 10.10.1.2")}},
+		stored:  time.Now().Add(-30 * time.Second),
+		expires: time.Now(),
+	})
+
+	cached, ok := responseCache.Lookup(question, "b-")
+	require.True(t, ok)
+	require.Equal(t, uint32(20), cached.Answer[0].Header().Ttl)
+	_, ok = responseCache.Lookup(question, "c-")
+	require.False(t, ok)
+}
+
+func TestShouldRefreshOnlyExpiredContributions(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	content := PrepareContentNextHandler(
+		map[string]Assertion{
+			"_http._tcp.demo.svc.cluster-a.local.": assertion,
+			"_http._tcp.demo.svc.cluster-b.local.": assertion,
+		},
+		map[string][]dns.RR{
+			"_http._tcp.demo.svc.cluster-a.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-a.local. 1 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+			},
+			"_http._tcp.demo.svc.cluster-b.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+			},
+		},
+		map[string][]dns.RR{},
+	)
+	var lock sync.Mutex
+	var asked []string
+	next := test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		lock.Lock()
+		asked = append(asked, r.Question[0].Name)
+		lock.Unlock()
+		return content.ServeDNS(ctx, w, r)
+	})
+	responseCache, _ := NewResponseCache(3600, 10)
+	gatherPlugin := &GatherSrv{
+		Next:   next,
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Cache: responseCache,
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 2)
+	require.Len(t, asked, 2)
+
+	msg = CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 2)
+	require.Len(t, asked, 2, "Expected merged response served from cache")
+
+	time.Sleep(1100 * time.Millisecond)
+	msg = CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 2)
+	require.Len(t, asked, 3, "Expected only the expired contribution to be refreshed")
+	require.Equal(t, "_http._tcp.demo.svc.cluster-a.local.", asked[2])
+}
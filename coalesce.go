@@ -0,0 +1,76 @@
+package gathersrv
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+	"sync"
+)
+
+// Coalescer lets only one of concurrent identical questions send sub-requests to clusters,
+// the remaining ones wait for its merged response and receive copies of it. The question is gathered within the context
+// and with the response writer of the first request, so its cancellation and client address affect all waiting requests.
+type Coalescer struct {
+	lock    sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	resp *NextResp
+}
+
+// NewCoalescer returns Coalescer without questions in flight.
+func NewCoalescer() *Coalescer {
+	return &Coalescer{flights: map[string]*flight{}}
+}
+
+// Do calls gather unless an identical question is already in flight, in that case it waits for the in-flight result.
+// Each caller receives its own copy of the merged response, shared reports whether the result came from another question.
+func (c *Coalescer) Do(ctx context.Context, key string, gather func() *NextResp) (resp *NextResp, shared bool) {
+	c.lock.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.lock.Unlock()
+		select {
+		case <-f.done:
+			return f.share(), true
+		case <-ctx.Done():
+			return &NextResp{Code: dns.RcodeServerFailure, Err: ctx.Err()}, true
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.flights, key)
+		c.lock.Unlock()
+		close(f.done)
+	}()
+	f.resp = gather()
+	return f.share(), false
+}
+
+// share returns a copy of the flight result which could be modified by the caller
+func (f *flight) share() *NextResp {
+	if f.resp == nil {
+		return &NextResp{Code: dns.RcodeServerFailure, Err: fmt.Errorf("coalesced question has not been gathered")}
+	}
+	resp := &NextResp{Code: f.resp.Code, Err: f.resp.Err}
+	if f.resp.Msg != nil {
+		resp.Msg = f.resp.Msg.Copy()
+	}
+	return resp
+}
+
+// coalescingKey identifies questions which could share the merged response, EDNS presence and DO bit are taken into account
+func coalescingKey(r *dns.Msg) string {
+	edns, do := false, false
+	if opt := r.IsEdns0(); opt != nil {
+		edns, do = true, opt.Do()
+	}
+	question := r.Question[0]
+	return fmt.Sprintf("%s/%d/%d/%t/%t", strings.ToLower(question.Name), question.Qtype, question.Qclass, edns, do)
+}
@@ -0,0 +1,150 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldDistinguishCoalescedQuestions(t *testing.T) {
+	lower := new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA)
+	upper := new(dns.Msg).SetQuestion("Demo.SVC.distro.local.", dns.TypeA)
+	aaaa := new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeAAAA)
+	signed := new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA).SetEdns0(4096, true)
+
+	require.Equal(t, coalescingKey(lower), coalescingKey(upper))
+	require.NotEqual(t, coalescingKey(lower), coalescingKey(aaaa))
+	require.NotEqual(t, coalescingKey(lower), coalescingKey(signed))
+}
+
+func TestShouldGatherConcurrentIdenticalQuestionsOnce(t *testing.T) {
+	coalescer := NewCoalescer()
+	release := make(chan struct{})
+	var calls int32
+	gather := func() *NextResp {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &NextResp{Code: dns.RcodeSuccess, Msg: new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA)}
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*NextResp, 5)
+	shared := make([]bool, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], shared[i] = coalescer.Do(context.TODO(), "key", gather)
+		}(i)
+	}
+	require.Eventually(t, func() bool {
+		coalescer.lock.Lock()
+		defer coalescer.lock.Unlock()
+		return len(coalescer.flights) == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls)
+	leaders := 0
+	for i, resp := range responses {
+		if !shared[i] {
+			leaders++
+		}
+		require.Equal(t, dns.RcodeSuccess, resp.Code)
+		for j := i + 1; j < len(responses); j++ {
+			require.NotSame(t, resp.Msg, responses[j].Msg)
+		}
+	}
+	require.Equal(t, 1, leaders)
+	require.Empty(t, coalescer.flights)
+}
+
+func TestShouldStopWaitingForCoalescedQuestionOnContextDone(t *testing.T) {
+	coalescer := NewCoalescer()
+	release := make(chan struct{})
+	defer close(release)
+	go coalescer.Do(context.TODO(), "key", func() *NextResp {
+		<-release
+		return &NextResp{}
+	})
+	require.Eventually(t, func() bool {
+		coalescer.lock.Lock()
+		defer coalescer.lock.Unlock()
+		return len(coalescer.flights) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	resp, shared := coalescer.Do(ctx, "key", func() *NextResp {
+		t.Fatal("Expected question to be coalesced")
+		return nil
+	})
+	require.True(t, shared)
+	require.Equal(t, dns.RcodeServerFailure, resp.Code)
+	require.ErrorIs(t, resp.Err, context.DeadlineExceeded)
+}
+
+func TestShouldAnswerCoalescedRequestsWithTheirOwnIds(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	content := PrepareContentNextHandler(
+		map[string]Assertion{
+			"_http._tcp.demo.svc.cluster-a.local.": assertion,
+			"_http._tcp.demo.svc.cluster-b.local.": assertion,
+		},
+		map[string][]dns.RR{
+			"_http._tcp.demo.svc.cluster-a.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+			},
+			"_http._tcp.demo.svc.cluster-b.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+			},
+		},
+		map[string][]dns.RR{},
+	)
+	var calls int32
+	next := test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return content.ServeDNS(ctx, w, r)
+	})
+	gatherPlugin := &GatherSrv{
+		Next:   next,
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Coalescer: NewCoalescer(),
+	}
+
+	var wg sync.WaitGroup
+	for id := uint16(1); id <= 10; id++ {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			req := NewDnsMsg(assertion)
+			req.Id = id
+			code, err := gatherPlugin.ServeDNS(context.TODO(), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, code)
+			require.Equal(t, id, rec.Msg.Id)
+			require.Len(t, rec.Msg.Answer, 2)
+		}(id)
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), calls, "Expected a single set of sub-requests")
+}
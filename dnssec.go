@@ -0,0 +1,35 @@
+package gathersrv
+
+import "github.com/miekg/dns"
+
+// dnssecTypes lists types of signatures and denial of existence records which become invalid after masquerading
+var dnssecTypes = map[uint16]bool{
+	dns.TypeRRSIG: true,
+	dns.TypeNSEC:  true,
+	dns.TypeNSEC3: true,
+}
+
+// clearDO asks the cluster not to send DNSSEC records in the response to the sub-request
+func clearDO(request *dns.Msg) {
+	if opt := request.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+}
+
+// stripDNSSEC removes signatures and denial of existence records from the sub-response, so the merged response is consistently unsigned
+func stripDNSSEC(response *dns.Msg) {
+	response.AuthenticatedData = false
+	response.Answer = withoutDNSSEC(response.Answer)
+	response.Ns = withoutDNSSEC(response.Ns)
+	response.Extra = withoutDNSSEC(response.Extra)
+}
+
+func withoutDNSSEC(records []dns.RR) []dns.RR {
+	filtered := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if !dnssecTypes[rr.Header().Rrtype] {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
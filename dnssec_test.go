@@ -0,0 +1,52 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShouldStripDNSSECRecordsFromSubResponses(t *testing.T) {
+	var signedSubRequests int
+	gatherPlugin := &GatherSrv{
+		Next: test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			if opt := r.IsEdns0(); opt == nil || opt.Do() {
+				signedSubRequests++
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.AuthenticatedData = true
+			m.Answer = []dns.RR{
+				test.A("demo.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+				test.RRSIG("demo.svc.cluster-a.local. 30 IN RRSIG A 13 4 30 20300101000000 20200101000000 12345 cluster-a.local. c2lnbmF0dXJl"),
+			}
+			m.Ns = []dns.RR{
+				test.NSEC("demo.svc.cluster-a.local. 30 IN NSEC next.svc.cluster-a.local. A RRSIG NSEC"),
+			}
+			m.Extra = []dns.RR{test.OPT(4096, false)}
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
+		}),
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+	}
+	req := new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA)
+	req.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	_, err := gatherPlugin.ServeDNS(context.TODO(), rec, req)
+	require.NoError(t, err)
+	require.Zero(t, signedSubRequests, "Expected DO bit to be cleared in sub-requests")
+	require.True(t, req.IsEdns0().Do(), "Expected the client request to be left untouched")
+	require.False(t, rec.Msg.AuthenticatedData)
+	require.Equal(t, []string{"a-demo.svc.distro.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+	require.Empty(t, rec.Msg.Ns)
+	for _, rr := range rec.Msg.Extra {
+		require.Equal(t, dns.TypeOPT, rr.Header().Rrtype)
+	}
+}
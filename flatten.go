@@ -0,0 +1,79 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
+	"math"
+	"strings"
+)
+
+const defaultFlattenHops = 8
+
+// flatten replaces CNAME chain starting at the question name with address records of its final target placed under the question name.
+// Targets without address records in the response are resolved by the same cluster as long as the chain does not exceed the hop limit.
+func (s *subRequest) flatten(ctx context.Context, w dns.ResponseWriter, resp *NextResp) *NextResp {
+	question := s.request.Question[0]
+	if (question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA) || !resp.successful() {
+		return resp
+	}
+	records := resp.Msg.Answer
+	name, ttl, hops := question.Name, uint32(math.MaxUint32), 0
+	chased := map[string]bool{}
+	for {
+		if cname := findCNAME(records, name); cname != nil {
+			if hops++; hops > s.flattenHops {
+				log.Warningf("CNAME chain of %s exceeds %d hops, records of cluster %s are dropped", question.Name, s.flattenHops, s.prefix)
+				return flattened(resp, nil)
+			}
+			name, ttl = cname.Target, min(ttl, cname.Hdr.Ttl)
+			continue
+		}
+		if hops == 0 {
+			return resp
+		}
+		addresses := findRecords(records, name, question.Qtype)
+		if len(addresses) == 0 && !chased[strings.ToLower(name)] {
+			chased[strings.ToLower(name)] = true
+			request := s.request.Copy()
+			request.Question[0].Name = name
+			if chasedResp := s.exchange(ctx, w, s.handler, request); chasedResp.successful() {
+				records = append(records, chasedResp.Msg.Answer...)
+				continue
+			}
+		}
+		answer := make([]dns.RR, 0, len(addresses))
+		for _, rr := range addresses {
+			record := dns.Copy(rr)
+			record.Header().Name = question.Name
+			record.Header().Ttl = min(ttl, record.Header().Ttl)
+			answer = append(answer, record)
+		}
+		return flattened(resp, answer)
+	}
+}
+
+// flattened returns a copy of the sub-response with the answer replaced
+func flattened(resp *NextResp, answer []dns.RR) *NextResp {
+	msg := resp.Msg.Copy()
+	msg.Answer = answer
+	return &NextResp{Code: resp.Code, Err: resp.Err, Msg: msg, prefix: resp.prefix, timeout: resp.timeout}
+}
+
+func findCNAME(records []dns.RR, name string) *dns.CNAME {
+	for _, rr := range records {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname
+		}
+	}
+	return nil
+}
+
+func findRecords(records []dns.RR, name string, rrtype uint16) (found []dns.RR) {
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrtype && strings.EqualFold(rr.Header().Name, name) {
+			found = append(found, rr)
+		}
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestShouldFlattenCNAMEChains(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{
+				"demo.svc.cluster-a.local.": assertion,
+				"demo.svc.cluster-b.local.": assertion,
+				"demo.svc.cluster-c.local.": assertion,
+				"db.example.com.":           assertion,
+			},
+			map[string][]dns.RR{
+				"demo.svc.cluster-a.local.": {
+					test.CNAME("demo.svc.cluster-a.local. 10 IN CNAME backend.svc.cluster-a.local."),
+					test.A("backend.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+				},
+				"demo.svc.cluster-b.local.": {
+					test.CNAME("demo.svc.cluster-b.local. 30 IN CNAME db.example.com."),
+				},
+				"demo.svc.cluster-c.local.": {
+					test.A("demo.svc.cluster-c.local. 30 IN A 10.10.1.2"),
+				},
+				"db.example.com.": {
+					test.A("db.example.com. 20 IN A 192.0.2.10"),
+				},
+			},
+			map[string][]dns.RR{},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+			{Suffix: "cluster-c.local.", Prefix: "c-"},
+		},
+		FlattenHops: defaultFlattenHops,
+	}
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.ElementsMatch(
+		t,
+		[]string{
+			"a-demo.svc.distro.local.\t10\tIN\tA\t10.8.1.2",
+			"b-demo.svc.distro.local.\t20\tIN\tA\t192.0.2.10",
+			"c-demo.svc.distro.local.\t30\tIN\tA\t10.10.1.2",
+		},
+		RecordsAsStrings(msg.Answer),
+	)
+}
+
+func TestShouldDropCNAMEChainsExceedingHopLimit(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	answers := map[string][]dns.RR{
+		"demo.svc.cluster-a.local.": {
+			test.CNAME("demo.svc.cluster-a.local. 30 IN CNAME first.svc.cluster-a.local."),
+			test.CNAME("first.svc.cluster-a.local. 30 IN CNAME second.svc.cluster-a.local."),
+			test.A("second.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+		},
+	}
+	for hops, expected := range map[int][]string{
+		1: nil,
+		2: {"a-demo.svc.distro.local.\t30\tIN\tA\t10.8.1.2"},
+	} {
+		gatherPlugin := &GatherSrv{
+			Next: PrepareContentNextHandler(
+				map[string]Assertion{"demo.svc.cluster-a.local.": assertion},
+				answers,
+				map[string][]dns.RR{},
+			),
+			Domain:      "distro.local.",
+			Clusters:    []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+			FlattenHops: hops,
+		}
+		msg := CheckAssertion(t, gatherPlugin, assertion)
+		require.Equalf(t, expected, RecordsAsStrings(msg.Answer), "Unexpected answer for hop limit: %d", hops)
+	}
+}
+
+func TestShouldChaseCNAMETargetsWithinTimeout(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	next := PrepareDelayedNextHandler(
+		PrepareContentNextHandler(
+			map[string]Assertion{
+				"demo.svc.cluster-a.local.": assertion,
+				"demo.svc.cluster-b.local.": assertion,
+				"db.example.com.":           assertion,
+			},
+			map[string][]dns.RR{
+				"demo.svc.cluster-a.local.": {test.CNAME("demo.svc.cluster-a.local. 30 IN CNAME db.example.com.")},
+				"demo.svc.cluster-b.local.": {test.A("demo.svc.cluster-b.local. 30 IN A 10.9.1.2")},
+				"db.example.com.":           {test.A("db.example.com. 20 IN A 192.0.2.10")},
+			},
+			map[string][]dns.RR{},
+		),
+		map[string]time.Duration{"db.example.com.": 500 * time.Millisecond},
+	)
+	gatherPlugin := &GatherSrv{
+		Next:    next,
+		Domain:  "distro.local.",
+		Timeout: 50 * time.Millisecond,
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		FlattenHops: defaultFlattenHops,
+	}
+
+	start := time.Now()
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Less(t, time.Since(start), 200*time.Millisecond)
+	require.Equal(t, []string{"b-demo.svc.distro.local.\t30\tIN\tA\t10.9.1.2"}, RecordsAsStrings(msg.Answer))
+}
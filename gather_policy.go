@@ -0,0 +1,61 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"time"
+)
+
+type gatherMode int
+
+const (
+	gatherAll gatherMode = iota
+	gatherQuorum
+	gatherFirst
+)
+
+var gatherModeNames = map[string]gatherMode{
+	"all":    gatherAll,
+	"quorum": gatherQuorum,
+	"first":  gatherFirst,
+}
+
+// GatherPolicy decides when gathering of sub-responses is complete:
+// * all - wait for all sub-requests (default)
+// * quorum - wait until majority of sub-requests succeeded
+// * first N - wait until N sub-requests succeeded
+// In the last two modes, remaining sub-responses are still merged if they arrive within the grace window.
+type GatherPolicy struct {
+	mode  gatherMode
+	count int
+	grace time.Duration
+}
+
+// NewGatherPolicy returns GatherPolicy for the mode name, count is taken into account only in "first" mode.
+func NewGatherPolicy(mode string, count int, grace time.Duration) (GatherPolicy, error) {
+	gatherMode, ok := gatherModeNames[mode]
+	if !ok {
+		return GatherPolicy{}, fmt.Errorf("unknown gather mode <%s>", mode)
+	}
+	if gatherMode == gatherFirst && count <= 0 {
+		return GatherPolicy{}, fmt.Errorf("gather mode <first> requires positive number of clusters")
+	}
+	return GatherPolicy{mode: gatherMode, count: count, grace: grace}, nil
+}
+
+// required returns the number of successful sub-responses after which gathering is complete
+func (gp GatherPolicy) required(subRequests int) int {
+	switch gp.mode {
+	case gatherQuorum:
+		return subRequests/2 + 1
+	case gatherFirst:
+		return min(gp.count, subRequests)
+	default:
+		return subRequests
+	}
+}
+
+// successful reports whether the sub-request delivered a usable response
+func (nr *NextResp) successful() bool {
+	return nr.Err == nil && nr.Msg != nil && nr.Msg.Rcode != dns.RcodeServerFailure
+}
@@ -0,0 +1,26 @@
+package gathersrv
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShouldComputeRequiredNumberOfSubResponses(t *testing.T) {
+	all, _ := NewGatherPolicy("all", 0, 0)
+	quorum, _ := NewGatherPolicy("quorum", 0, 0)
+	firstTwo, _ := NewGatherPolicy("first", 2, 0)
+
+	require.Equal(t, 3, all.required(3))
+	require.Equal(t, 2, quorum.required(3))
+	require.Equal(t, 3, quorum.required(4))
+	require.Equal(t, 1, quorum.required(1))
+	require.Equal(t, 2, firstTwo.required(3))
+	require.Equal(t, 1, firstTwo.required(1))
+}
+
+func TestShouldFailIfGatherPolicyIsIncorrect(t *testing.T) {
+	_, err := NewGatherPolicy("some", 0, 0)
+	require.Error(t, err)
+	_, err = NewGatherPolicy("first", 0, 0)
+	require.Error(t, err)
+}
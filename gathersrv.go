@@ -2,46 +2,260 @@ package gathersrv
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
 	"github.com/miekg/dns"
 	"strings"
 	"time"
 )
 
-var proxyTypes = [...]uint16{dns.TypeSRV, dns.TypeA, dns.TypeAAAA, dns.TypeTXT}
+var proxyTypes = [...]uint16{dns.TypeSRV, dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeSVCB, dns.TypeHTTPS, dns.TypePTR}
+
+var errSubRequestTimeout = errors.New("sub-request timeout")
 
 type Cluster struct {
 	Suffix string
 	Prefix string
+	// Upstream resolves sub-requests for the cluster, if nil sub-requests are passed to the next plugin
+	Upstream *Upstream
+	// Timeout bounds sub-requests sent to the cluster, if zero GatherSrv.Timeout is used
+	Timeout time.Duration
+	// Hedge defines when a duplicate of a slow sub-request is sent to the cluster, if nil sub-requests are not hedged
+	Hedge *Hedge
+	// Retry defines how failed sub-requests are repeated, if nil sub-requests are not retried
+	Retry *RetryPolicy
+	// Breaker stops sending sub-requests to the cluster while it is failing, if nil sub-requests are always sent
+	Breaker *CircuitBreaker
+	// TTL overrides TTL of records returned by the cluster, if zero TTLs are kept
+	TTL uint32
+	// ReverseZones lists reverse zones of the cluster addresses, PTR questions within them are sent to the cluster
+	ReverseZones []string
+}
+
+// handler returns plugin.Handler which should resolve sub-requests for the cluster
+func (c Cluster) handler(next plugin.Handler) plugin.Handler {
+	if c.Upstream != nil {
+		return c.Upstream
+	}
+	return next
+}
+
+// ownsReverseName reports whether the reverse name belongs to any of the cluster reverse zones
+func (c Cluster) ownsReverseName(name string) bool {
+	for _, zone := range c.ReverseZones {
+		if dns.IsSubDomain(zone, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgeHandler returns plugin.Handler which should resolve hedged sub-requests for the cluster
+func (c Cluster) hedgeHandler(next plugin.Handler) plugin.Handler {
+	if c.Upstream != nil {
+		return c.Upstream.alternate()
+	}
+	return next
 }
 
 type GatherSrv struct {
 	Next     plugin.Handler
 	Domain   string
 	Clusters []Cluster
+	// Types lists types of gathered questions, if nil proxyTypes are gathered
+	Types []uint16
+	// Timeout bounds each sub-request, zero means waiting until the request context is done
+	Timeout time.Duration
+	// Policy decides when gathering of sub-responses is complete
+	Policy GatherPolicy
+	// Health checks clusters in the background, if nil all clusters are considered healthy
+	Health *HealthChecker
+	// Stale remembers records of clusters to serve them when a cluster fails, if nil failed clusters are omitted
+	Stale *StaleStore
+	// Cache keeps contributions of clusters to merged responses, if nil all sub-requests are sent
+	Cache *ResponseCache
+	// TTL normalizes TTL of merged records, if nil TTLs returned by clusters are kept
+	TTL *TTLPolicy
+	// FlattenHops limits CNAME chains replaced with address records of their targets, if zero CNAME records are kept
+	FlattenHops int
+	// KeepDuplicates disables deduplication of identical records returned by different clusters
+	KeepDuplicates bool
+	// TXT decides which TXT records of clusters are merged, if nil records of all clusters are merged
+	TXT *TXTPolicy
+	// Precedence decides the merged response code, if nil NOERROR, NODATA, SERVFAIL, NXDOMAIN order is used
+	Precedence *RcodePrecedence
+	// Authority provides SOA record of negative merged responses, if nil their authority section is empty
+	Authority *Authority
+	// Coalescer shares the merged response among concurrent identical questions, if nil each question is gathered
+	Coalescer *Coalescer
+	// Signer signs merged responses for clients asking for DNSSEC records, if nil responses are unsigned
+	Signer *Signer
+	// StrictBailiwick drops records of each cluster outside its domain, if false such records are merged untranslated
+	StrictBailiwick bool
 }
 
 type NextResp struct {
-	Code  int
-	Err   error
-	empty bool
+	Code    int
+	Err     error
+	Msg     *dns.Msg
+	prefix  string
+	timeout bool
+	empty   bool
+	// class and precedence are used only by the merged response
+	class      outcome
+	precedence *RcodePrecedence
 }
 
 func (nr *NextResp) Reduce(subsequentResponse *NextResp) {
-	// return no error if at least one sub-request went well
-	if nr.empty || (nr.Err != nil && subsequentResponse.Err == nil) {
+	// the sub-response of the class with the highest precedence determines the merged response code
+	precedence := nr.precedence
+	if precedence == nil {
+		precedence = defaultRcodePrecedence
+	}
+	class := classify(subsequentResponse)
+	if nr.empty || precedence.prevails(nr.class, class) {
 		nr.empty = false
+		nr.class = class
 		nr.Err = subsequentResponse.Err
-		nr.Code = subsequentResponse.Code
+		nr.Code = outcomeRcodes[class]
 	}
 }
 
 type subRequest struct {
-	prefix  string
-	request *dns.Msg
+	prefix       string
+	handler      plugin.Handler
+	hedge        *Hedge
+	hedgeHandler plugin.Handler
+	retry        *RetryPolicy
+	breaker      *CircuitBreaker
+	timeout      time.Duration
+	ttl          uint32
+	flattenHops  int
+	// bailiwick lists zones of records accepted from the cluster, if empty all records are accepted
+	bailiwick []string
+	request   *dns.Msg
+}
+
+func (gatherSrv GatherSrv) newSubRequest(cluster Cluster, request *dns.Msg) *subRequest {
+	timeout := cluster.Timeout
+	if timeout == 0 {
+		timeout = gatherSrv.Timeout
+	}
+	sr := &subRequest{
+		prefix:       cluster.Prefix,
+		handler:      cluster.handler(gatherSrv.Next),
+		hedge:        cluster.Hedge,
+		hedgeHandler: cluster.hedgeHandler(gatherSrv.Next),
+		retry:        cluster.Retry,
+		breaker:      cluster.Breaker,
+		timeout:      timeout,
+		ttl:          cluster.TTL,
+		flattenHops:  gatherSrv.FlattenHops,
+		request:      request,
+	}
+	if gatherSrv.StrictBailiwick {
+		sr.bailiwick = bailiwickOf(cluster, request.Question[0])
+	}
+	return sr
+}
+
+// resolve passes sub-request to the cluster handler and captures its response instead of writing it to the client.
+// If the sub-request has a timeout defined, the response is abandoned after it passes.
+// If the sub-request is hedged and the cluster is slow, a duplicate is sent and the first successful response wins.
+func (s *subRequest) resolve(ctx context.Context, w dns.ResponseWriter) *NextResp {
+	subCtx, cancel := ctx, context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		subCtx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	defer cancel()
+
+	done := make(chan *NextResp, 2)
+	attempt := func(handler plugin.Handler, request *dns.Msg) {
+		resp := s.exchange(subCtx, w, handler, request)
+		if s.flattenHops > 0 {
+			// targets of CNAME chains are chased within the timeout of the sub-request
+			resp = s.flatten(subCtx, w, resp)
+		}
+		done <- resp
+	}
+	go attempt(s.handler, s.request)
+	pending := 1
+
+	var hedge <-chan time.Time
+	if s.hedge != nil {
+		if delay, ok := s.hedge.Delay(); ok {
+			hedge = time.After(delay)
+		}
+	}
+
+	var resp *NextResp
+	for resp == nil || (resp.Err != nil && pending > 0) {
+		select {
+		case resp = <-done:
+			pending--
+		case <-hedge:
+			hedge = nil
+			pending++
+			subRequestHedgeCount.WithLabelValues(
+				metrics.WithServer(ctx), s.prefix, dns.Type(s.request.Question[0].Qtype).String(),
+			).Inc()
+			go attempt(s.hedgeHandler, s.request.Copy())
+		case <-subCtx.Done():
+			pending = 0
+			if resp == nil {
+				resp = &NextResp{Code: dns.RcodeServerFailure, Err: subCtx.Err(), prefix: s.prefix}
+			}
+		}
+	}
+	if resp.Err != nil && ctx.Err() == nil && errors.Is(subCtx.Err(), context.DeadlineExceeded) {
+		return &NextResp{Code: dns.RcodeServerFailure, Err: errSubRequestTimeout, prefix: s.prefix, timeout: true}
+	}
+	return resp
+}
+
+// exchange passes sub-request to the handler, repeating it as long as the retry policy allows
+func (s *subRequest) exchange(ctx context.Context, w dns.ResponseWriter, handler plugin.Handler, request *dns.Msg) *NextResp {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		nw := nonwriter.New(w)
+		code, err := plugin.NextOrFailure(gatherSrvPluginName, handler, ctx, nw, request)
+		if err == nil && s.hedge != nil {
+			s.hedge.Observe(time.Since(start))
+		}
+		resp := &NextResp{Code: code, Err: err, Msg: nw.Msg, prefix: s.prefix}
+		if truncated(resp) {
+			resp = s.requeryOverTCP(ctx, w, handler, request, resp)
+		}
+		if s.retry == nil || !s.retry.retryable(resp, attempt) {
+			return resp
+		}
+		subRequestRetryCount.WithLabelValues(
+			metrics.WithServer(ctx), s.prefix, dns.Type(request.Question[0].Qtype).String(),
+		).Inc()
+		select {
+		case <-time.After(s.retry.delay(attempt)):
+		case <-ctx.Done():
+			return resp
+		}
+	}
+}
+
+// requeryOverTCP repeats the truncated sub-request over TCP, if the response is still truncated the sub-request is considered failed
+// as merging partial records would hide records of the cluster
+func (s *subRequest) requeryOverTCP(ctx context.Context, w dns.ResponseWriter, handler plugin.Handler, request *dns.Msg, resp *NextResp) *NextResp {
+	if tw, ok := overTCP(w); ok {
+		nw := nonwriter.New(tw)
+		code, err := plugin.NextOrFailure(gatherSrvPluginName, handler, ctx, nw, request)
+		resp = &NextResp{Code: code, Err: err, Msg: nw.Msg, prefix: s.prefix}
+	}
+	if truncated(resp) {
+		return &NextResp{Code: dns.RcodeServerFailure, Err: errSubResponseTruncated, prefix: s.prefix}
+	}
+	return resp
 }
 
 // we need a channel that:
@@ -89,83 +303,292 @@ func newClosableChannel[T any](size int) *closableChannel[T] {
 
 func (gatherSrv GatherSrv) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	questionType := dns.Type(r.Question[0].Qtype).String()
+	if gatherSrv.Signer != nil && gatherSrv.Signer.IsKeyQuestion(r.Question[0]) {
+		requestCount.WithLabelValues(metrics.WithServer(ctx), "true", questionType).Inc()
+		return gatherSrv.respond(w, r, &NextResp{Code: dns.RcodeSuccess, Msg: gatherSrv.Signer.Keys(ctx, r)})
+	}
 	if !gatherSrv.IsQualifiedQuestion(r.Question[0]) {
 		requestCount.WithLabelValues(metrics.WithServer(ctx), "false", questionType).Inc()
 		return plugin.NextOrFailure(gatherSrv.Name(), gatherSrv.Next, ctx, w, r)
 	}
 	requestCount.WithLabelValues(metrics.WithServer(ctx), "true", questionType).Inc()
 
-	// build proper number of sub-requests depends on defined clusters
-	subRequests := gatherSrv.prepareSubRequests(r)
+	if gatherSrv.Coalescer == nil {
+		return gatherSrv.respond(w, r, gatherSrv.gather(ctx, w, r))
+	}
+	// only one of concurrent identical questions is gathered, the remaining ones receive copies of its response
+	resp, shared := gatherSrv.Coalescer.Do(ctx, coalescingKey(r), func() *NextResp {
+		return gatherSrv.gather(ctx, w, r)
+	})
+	if shared {
+		coalescedRequestCount.WithLabelValues(metrics.WithServer(ctx), questionType).Inc()
+	}
+	return gatherSrv.respond(w, r, resp)
+}
+
+// gather sends sub-requests to clusters and merges their responses, the merged response is returned in NextResp.Msg
+func (gatherSrv GatherSrv) gather(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) *NextResp {
+	questionType := dns.Type(r.Question[0].Qtype).String()
+
+	// build proper number of sub-requests depends on defined clusters,
+	// skip clusters with cached contributions and clusters known to be failing
+	prepared := gatherSrv.prepareSubRequests(r)
+	subRequests, cached := gatherSrv.lookupCache(ctx, r, prepared)
+	subRequests, skipped := gatherSrv.selectSubRequests(ctx, subRequests)
 	respChan := newClosableChannel[*NextResp](len(subRequests))
 	defer respChan.Close()
-	pw := NewResponsePrinter(w, r, gatherSrv.Domain, gatherSrv.Clusters, len(subRequests))
+	pw := NewResponsePrinter(w, r, gatherSrv.Domain, gatherSrv.Clusters, len(subRequests)+len(cached))
+	pw.authority = gatherSrv.Authority
+	pw.keepDuplicates = gatherSrv.KeepDuplicates
+	pw.txtPolicy = gatherSrv.TXT
+	for _, s := range skipped {
+		pw.Skipped(s.prefix)
+	}
+	mergedResponse := &NextResp{empty: true, precedence: gatherSrv.Precedence}
+	answered := map[string]bool{}
+	for _, cachedResponse := range cached {
+		pw.ContributeCached(cachedResponse.prefix, cachedResponse.Msg)
+		mergedResponse.Reduce(cachedResponse)
+		answered[cachedResponse.prefix] = true
+	}
 
 	// call sub-requests in parallel manner
-	doSubRequest := func(ctx context.Context, pw dns.ResponseWriter, s *subRequest) {
-		code, err := plugin.NextOrFailure(gatherSrv.Name(), gatherSrv.Next, ctx, pw, s.request)
-		subRequestCount.WithLabelValues(metrics.WithServer(ctx), s.prefix, questionType, fmt.Sprintf("%d", code)).Inc()
-		if err != nil {
+	doSubRequest := func(ctx context.Context, w dns.ResponseWriter, s *subRequest) {
+		resp := s.resolve(ctx, w)
+		if len(s.bailiwick) > 0 && resp.Msg != nil {
+			var dropped int
+			if resp.Msg, dropped = s.enforceBailiwick(resp.Msg); dropped > 0 {
+				droppedRecordCount.WithLabelValues(metrics.WithServer(ctx), s.prefix, questionType).Add(float64(dropped))
+				log.Warningf("Dropped %d records outside bailiwick of cluster %s for: type=%s, question=%s", dropped, s.prefix, questionType, s.request.Question[0].Name)
+			}
+		}
+		if s.ttl > 0 && resp.Msg != nil {
+			resp.Msg = resp.Msg.Copy()
+			setTTL(resp.Msg.Answer, s.ttl)
+			setTTL(resp.Msg.Extra, s.ttl)
+		}
+		if s.breaker != nil {
+			if ctx.Err() == nil || resp.successful() {
+				s.breaker.Record(resp.successful())
+				clusterBreakerState.WithLabelValues(metrics.WithServer(ctx), s.prefix).Set(float64(s.breaker.State()))
+			} else {
+				// failures caused by the canceled request do not count, but the probe has to be released
+				s.breaker.Abandon()
+			}
+		}
+		subRequestCount.WithLabelValues(metrics.WithServer(ctx), s.prefix, questionType, fmt.Sprintf("%d", resp.Code)).Inc()
+		if resp.timeout {
+			subRequestTimeoutCount.WithLabelValues(metrics.WithServer(ctx), s.prefix, questionType).Inc()
+		}
+		if resp.Err != nil {
 			log.Warningf(
 				"Error occurred for: type=%s, question=%s, error=%s",
 				questionType,
 				s.request.Question[0].Name,
-				err,
+				resp.Err,
 			)
 		}
-		respChan.Deposit(&NextResp{Code: code, Err: err})
+		respChan.Deposit(resp)
 	}
 	for _, subRequestParams := range subRequests {
-		go doSubRequest(ctx, pw, subRequestParams)
+		go doSubRequest(ctx, w, subRequestParams)
 	}
 
-	// gather all responses or return partial response on context done
-	mergedResponse := &NextResp{empty: true}
+	// gather responses required by the policy or return partial response on context done
+	required, successful := gatherSrv.Policy.required(len(subRequests)), 0
+	var grace <-chan time.Time
 	for waitCnt := len(subRequests); waitCnt > 0; waitCnt-- {
 		select {
 		case subResponse := <-respChan.Read():
+			if subResponse.timeout {
+				pw.TimedOut(subResponse.prefix)
+			}
+			if subResponse.Msg != nil {
+				masqueraded := pw.Contribute(subResponse.prefix, subResponse.Msg)
+				if gatherSrv.Stale != nil && subResponse.successful() {
+					gatherSrv.Stale.Remember(r.Question[0], subResponse.prefix, masqueraded)
+				}
+				if gatherSrv.Cache != nil && subResponse.successful() {
+					gatherSrv.Cache.Store(r.Question[0], subResponse.prefix, masqueraded)
+				}
+			}
 			mergedResponse.Reduce(subResponse)
+			if subResponse.successful() {
+				answered[subResponse.prefix] = true
+				if successful++; successful == required && waitCnt > 1 {
+					if gatherSrv.Policy.grace == 0 {
+						waitCnt = 0
+						break
+					}
+					grace = time.After(gatherSrv.Policy.grace)
+				}
+			}
+		case <-grace:
+			waitCnt = 0
 		case <-ctx.Done():
 			waitCnt = 0
 		}
 	}
-	pw.Flush(r)
-	return mergedResponse.Code, mergedResponse.Err
+	if gatherSrv.Stale != nil {
+		// replace contributions of failed clusters with their last good records
+		for _, s := range append(subRequests, skipped...) {
+			if answered[s.prefix] {
+				continue
+			}
+			if answer, extra, ok := gatherSrv.Stale.Recall(r.Question[0], s.prefix); ok {
+				pw.ContributeStale(s.prefix, answer, extra)
+				mergedResponse.Reduce(&NextResp{Code: dns.RcodeSuccess, Msg: &dns.Msg{Answer: answer}})
+			}
+		}
+	}
+	if !mergedResponse.empty {
+		pw.SetRcode(mergedResponse.Code)
+	}
+	mergedResponse.Msg = pw.Response(r)
+	if gatherSrv.TTL != nil {
+		// the response is partial if any of targeted clusters has not contributed fresh records
+		gatherSrv.TTL.Apply(mergedResponse.Msg, len(answered) < len(prepared))
+	}
+	// signing happens last as any change of records invalidates signatures, denials turn NXDOMAIN into NODATA
+	if gatherSrv.Signer != nil && gatherSrv.Signer.Sign(ctx, r, mergedResponse.Msg) {
+		mergedResponse.Code = mergedResponse.Msg.Rcode
+	}
+	return mergedResponse
+}
+
+// respond writes the merged response to the client with the message id and the question of its request.
+// Once the response is written the success code is returned, otherwise CoreDNS would write another SERVFAIL response,
+// the error is returned only to be logged.
+func (gatherSrv GatherSrv) respond(w dns.ResponseWriter, r *dns.Msg, resp *NextResp) (int, error) {
+	if resp.Msg == nil {
+		return resp.Code, resp.Err
+	}
+	resp.Msg.Id = r.Id
+	resp.Msg.Question[0] = r.Question[0]
+	if err := w.WriteMsg(resp.Msg); err != nil {
+		log.Errorf("error occurred while writing response: question=%v, error=%s", r.Question[0], err)
+	}
+	return dns.RcodeSuccess, resp.Err
+}
+
+// lookupCache divides sub-requests into ones which should be sent and ones with contributions found in the cache
+func (gatherSrv GatherSrv) lookupCache(
+	ctx context.Context, r *dns.Msg, subRequests []*subRequest,
+) (remaining []*subRequest, cached []*NextResp) {
+	if gatherSrv.Cache == nil {
+		return subRequests, nil
+	}
+	for _, s := range subRequests {
+		if msg, ok := gatherSrv.Cache.Lookup(r.Question[0], s.prefix); ok {
+			cached = append(cached, &NextResp{Code: msg.Rcode, Msg: msg, prefix: s.prefix})
+			continue
+		}
+		remaining = append(remaining, s)
+	}
+	result := "miss"
+	if len(cached) > 0 {
+		result = "hit"
+		if len(remaining) > 0 {
+			result = "refresh"
+		}
+	}
+	cacheRequestCount.WithLabelValues(metrics.WithServer(ctx), dns.Type(r.Question[0].Qtype).String(), result).Inc()
+	return
+}
+
+// selectSubRequests divides sub-requests into ones which should be sent and ones skipped due to cluster failures
+func (gatherSrv GatherSrv) selectSubRequests(
+	ctx context.Context, subRequests []*subRequest,
+) (selected []*subRequest, skipped []*subRequest) {
+	for _, s := range subRequests {
+		if gatherSrv.Health != nil && !gatherSrv.Health.Healthy(s.prefix) {
+			skipped = append(skipped, s)
+			continue
+		}
+		if s.breaker != nil {
+			allowed := s.breaker.Allow()
+			clusterBreakerState.WithLabelValues(metrics.WithServer(ctx), s.prefix).Set(float64(s.breaker.State()))
+			if !allowed {
+				skipped = append(skipped, s)
+				continue
+			}
+		}
+		selected = append(selected, s)
+	}
+	return
 }
 
 func (gatherSrv GatherSrv) prepareSubRequests(r *dns.Msg) (calls []*subRequest) {
+	if r.Question[0].Qtype == dns.TypePTR {
+		// reverse names are the same in all clusters, so the question is sent as it is to clusters owning the address
+		for _, cluster := range gatherSrv.Clusters {
+			if cluster.ownsReverseName(r.Question[0].Name) {
+				sr := r.Copy()
+				clearDO(sr)
+				calls = append(calls, gatherSrv.newSubRequest(cluster, sr))
+			}
+		}
+		return
+	}
 	question := r.Question[0].Name
-	protocolPrefix, questionWithoutPrefix := divideDomain(r.Question[0].Name)
-
 	for _, cluster := range gatherSrv.Clusters {
-		if strings.HasPrefix(questionWithoutPrefix, cluster.Prefix) {
-			sr := r.Copy()
-			sr.Question[0].Name = protocolPrefix + strings.Replace(
-				strings.TrimPrefix(questionWithoutPrefix, cluster.Prefix), gatherSrv.Domain, cluster.Suffix, 1,
-			)
-			calls = append(calls, &subRequest{cluster.Prefix, sr})
+		if stripped, ok := stripPrefix(question, cluster.Prefix, gatherSrv.Domain); ok {
+			if name, ok := toCluster(stripped, cluster, gatherSrv.Domain); ok {
+				sr := r.Copy()
+				clearDO(sr)
+				sr.Question[0].Name = name
+				calls = append(calls, gatherSrv.newSubRequest(cluster, sr))
+			}
 		}
 	}
 
 	if len(calls) == 0 {
 		for _, cluster := range gatherSrv.Clusters {
-			sr := r.Copy()
-			sr.Question[0].Name = strings.Replace(question, gatherSrv.Domain, cluster.Suffix, 1)
-			calls = append(calls, &subRequest{cluster.Prefix, sr})
+			if name, ok := toCluster(question, cluster, gatherSrv.Domain); ok {
+				sr := r.Copy()
+				clearDO(sr)
+				sr.Question[0].Name = name
+				calls = append(calls, gatherSrv.newSubRequest(cluster, sr))
+			}
 		}
 	}
 	return
 }
 
 func (gatherSrv GatherSrv) IsQualifiedQuestion(question dns.Question) bool {
-	return IsProxyType(question.Qtype) && strings.HasSuffix(question.Name, gatherSrv.Domain)
+	if question.Qtype == dns.TypePTR {
+		// reverse names do not belong to the distributed domain, they are gathered only within reverse zones of clusters
+		if !gatherSrv.isProxyType(question.Qtype) {
+			return false
+		}
+		for _, cluster := range gatherSrv.Clusters {
+			if cluster.ownsReverseName(question.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	return gatherSrv.isProxyType(question.Qtype) && dns.IsSubDomain(gatherSrv.Domain, question.Name)
+}
+
+// isProxyType reports whether questions of the type are gathered, by default proxyTypes are used
+func (gatherSrv GatherSrv) isProxyType(questionType uint16) bool {
+	if gatherSrv.Types == nil {
+		return IsProxyType(questionType)
+	}
+	for _, proxyType := range gatherSrv.Types {
+		if proxyType == questionType {
+			return true
+		}
+	}
+	return false
 }
 
 // Name implements the Handler interface.
 func (gatherSrv GatherSrv) Name() string { return gatherSrvPluginName }
 
 // Ready implements ready.Readiness interface
-func (gatherSrv GatherSrv) Ready() bool { return true }
+func (gatherSrv GatherSrv) Ready() bool { return gatherSrv.Health == nil || gatherSrv.Health.Ready() }
 
 type GatherResponsePrinter struct {
 	originalQuestion dns.Question
@@ -173,6 +596,16 @@ type GatherResponsePrinter struct {
 	domain           string
 	counter          int
 	clusters         []Cluster
+	timedOut         []string
+	skipped          []string
+	stale            []string
+	authority        *Authority
+	keepDuplicates   bool
+	txtPolicy        *TXTPolicy
+	txt              map[string][]dns.RR
+	txtOrder         []string
+	soas             []*dns.SOA
+	request          *dns.Msg
 	state            *dns.Msg
 	start            time.Time
 	dns.ResponseWriter
@@ -187,20 +620,57 @@ func NewResponsePrinter(w dns.ResponseWriter, r *dns.Msg, domain string, cluster
 		domain:           domain,
 		clusters:         clusters,
 		counter:          counter,
+		request:          r,
+		txt:              map[string][]dns.RR{},
 		state:            nil,
 		start:            time.Now(),
 	}
 }
 
 func (w *GatherResponsePrinter) WriteMsg(res *dns.Msg) error {
+	w.Contribute("", res)
+	return nil
+}
+
+// Contribute merges the sub-response of the cluster with given prefix and returns its masqueraded copy.
+func (w *GatherResponsePrinter) Contribute(prefix string, res *dns.Msg) *dns.Msg {
 	w.lockCh <- true
 	defer func() {
 		<-w.lockCh
 	}()
 	state := res.Copy()
+	stripDNSSEC(state)
+	for _, rr := range state.Answer {
+		w.Masquerade(rr)
+	}
+	for _, rr := range state.Extra {
+		w.Masquerade(rr)
+	}
+	for _, rr := range state.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			w.masqueradeSOA(soa)
+		}
+	}
+	w.merge(prefix, state)
+	return state
+}
+
+// ContributeCached merges already masqueraded sub-response of the cluster with given prefix.
+func (w *GatherResponsePrinter) ContributeCached(prefix string, res *dns.Msg) {
+	w.lockCh <- true
+	defer func() {
+		<-w.lockCh
+	}()
+	w.merge(prefix, res)
+}
+
+func (w *GatherResponsePrinter) merge(prefix string, state *dns.Msg) {
 	if w.state == nil {
-		w.state = res.Copy()
+		w.state = state.Copy()
+		w.state.Id = w.request.Id
 		w.state.Question[0] = w.originalQuestion
+		// truncated sub-responses are never merged, so the merged response is complete
+		w.state.Truncated = false
 		w.state.Ns = []dns.RR{}
 		w.state.Answer = []dns.RR{}
 		w.state.Extra = []dns.RR{}
@@ -209,61 +679,172 @@ func (w *GatherResponsePrinter) WriteMsg(res *dns.Msg) error {
 			w.state.Rcode = state.Rcode
 			w.state.RecursionAvailable = state.RecursionAvailable
 			w.state.Authoritative = state.Authoritative
-			w.state.Truncated = state.Truncated
 		}
 	}
 
 	state.Question[0] = w.originalQuestion
-	for _, rr := range state.Answer {
-		w.Masquerade(rr)
-		w.state.Answer = append(w.state.Answer, rr)
-
+	for _, rr := range state.Ns {
+		// only records translated into the distributed domain could be used in the authority section
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(w.domain, soa.Hdr.Name) {
+			w.soas = append(w.soas, soa)
+		}
 	}
+	w.state.Answer = w.appendRecords(w.state.Answer, w.separateTXT(prefix, state.Rcode, state.Answer))
+	extra := make([]dns.RR, 0, len(state.Extra))
 	for _, rr := range state.Extra {
 		if rr.Header().Rrtype == dns.TypeOPT {
 			continue
 		}
-		w.Masquerade(rr)
-		w.state.Extra = append(w.state.Extra, rr)
+		extra = append(extra, rr)
 	}
+	w.state.Extra = w.appendRecords(w.state.Extra, extra)
 
-	if w.counter--; w.counter > 0 {
-		return nil
+	if w.counter--; w.counter == 0 {
+		for _, rr := range state.Extra {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				w.state.Extra = append(w.state.Extra, rr)
+			}
+		}
 	}
-	for _, rr := range state.Extra {
-		if rr.Header().Rrtype == dns.TypeOPT {
-			w.state.Extra = append(w.state.Extra, rr)
+	state.Extra = extra
+}
+
+// separateTXT keeps TXT records of the cluster aside if they are selected by TXT policy, remaining records are returned
+func (w *GatherResponsePrinter) separateTXT(prefix string, rcode int, answer []dns.RR) []dns.RR {
+	if !w.txtPolicy.gathered() || rcode != dns.RcodeSuccess {
+		return answer
+	}
+	remaining := make([]dns.RR, 0, len(answer))
+	if _, ok := w.txt[prefix]; !ok {
+		w.txtOrder = append(w.txtOrder, prefix)
+		w.txt[prefix] = []dns.RR{}
+	}
+	for _, rr := range answer {
+		if rr.Header().Rrtype == dns.TypeTXT {
+			w.txt[prefix] = append(w.txt[prefix], rr)
+			continue
 		}
+		remaining = append(remaining, rr)
 	}
-	return nil
+	return remaining
+}
+
+// appendRecords appends records to the merged section skipping records identical (by name, type, class and rdata)
+// to already merged ones - the lower TTL of duplicates is kept. Duplicates are appended if keepDuplicates is set.
+func (w *GatherResponsePrinter) appendRecords(section []dns.RR, records []dns.RR) []dns.RR {
+	if w.keepDuplicates {
+		return append(section, records...)
+	}
+	for _, rr := range records {
+		duplicated := false
+		for _, merged := range section {
+			if dns.IsDuplicate(merged, rr) {
+				merged.Header().Ttl = min(merged.Header().Ttl, rr.Header().Ttl)
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			section = append(section, rr)
+		}
+	}
+	return section
+}
+
+// ContributeStale merges records remembered from the previous response of the cluster with given prefix.
+func (w *GatherResponsePrinter) ContributeStale(prefix string, answer []dns.RR, extra []dns.RR) {
+	w.lockCh <- true
+	defer func() {
+		<-w.lockCh
+	}()
+	if w.state == nil {
+		w.state = new(dns.Msg).SetReply(w.request)
+		w.state.Question[0] = w.originalQuestion
+	} else if w.state.Rcode != dns.RcodeSuccess {
+		w.state.Rcode = dns.RcodeSuccess
+	}
+	w.state.Answer = w.appendRecords(w.state.Answer, w.separateTXT(prefix, dns.RcodeSuccess, answer))
+	w.state.Extra = w.appendRecords(w.state.Extra, extra)
+	w.stale = append(w.stale, prefix)
+}
+
+// SetRcode overrides the response code of the merged response with the one decided by the rcode precedence.
+func (w *GatherResponsePrinter) SetRcode(rcode int) {
+	w.lockCh <- true
+	defer func() {
+		<-w.lockCh
+	}()
+	if w.state != nil {
+		w.state.Rcode = rcode
+	}
+}
+
+// TimedOut marks that the sub-request sent to the cluster with given prefix has timed out.
+func (w *GatherResponsePrinter) TimedOut(prefix string) {
+	w.lockCh <- true
+	defer func() {
+		<-w.lockCh
+	}()
+	w.timedOut = append(w.timedOut, prefix)
+}
+
+// Skipped marks that the sub-request to the cluster with given prefix has not been sent.
+func (w *GatherResponsePrinter) Skipped(prefix string) {
+	w.lockCh <- true
+	defer func() {
+		<-w.lockCh
+	}()
+	w.skipped = append(w.skipped, prefix)
 }
 
 func (w *GatherResponsePrinter) Masquerade(rr dns.RR) {
-	// TODO: extract to specialized class
+	if ptrRecord, ok := rr.(*dns.PTR); ok {
+		// owner of PTR record is a reverse name, so the cluster is recognized by the target
+		for _, cluster := range w.clusters {
+			if dns.IsSubDomain(cluster.Suffix, ptrRecord.Ptr) {
+				masqueraders[dns.TypePTR](rr, cluster, w.domain, "", "")
+				return
+			}
+		}
+		return
+	}
 	for _, cluster := range w.clusters {
-		if strings.HasSuffix(rr.Header().Name, cluster.Suffix) {
-			replaceHead, replaceTail := divideDomain(strings.Replace(rr.Header().Name, cluster.Suffix, w.domain, 1))
-			switch rr.Header().Rrtype {
-			case dns.TypeSRV:
-				srvRecord := rr.(*dns.SRV)
-				srvRecord.Header().Name = replaceHead + replaceTail
-				head, tail := divideDomain(strings.Replace(srvRecord.Target, cluster.Suffix, w.domain, 1))
-				srvRecord.Target = fmt.Sprintf("%s%s%s", head, cluster.Prefix, tail)
-			case dns.TypeA:
-				rr.Header().Name = fmt.Sprintf("%s%s%s", replaceHead, cluster.Prefix, replaceTail)
-			case dns.TypeAAAA:
-				rr.Header().Name = fmt.Sprintf("%s%s%s", replaceHead, cluster.Prefix, replaceTail)
-			case dns.TypeOPT:
-				// TODO: test case
-				// do not merge OPT records
-			default:
+		if name, ok := fromCluster(rr.Header().Name, cluster, w.domain); ok {
+			replaceHead, replaceTail := divideDomain(name)
+			if masquerade, ok := masqueraders[rr.Header().Rrtype]; ok {
+				masquerade(rr, cluster, w.domain, replaceHead, replaceTail)
+			} else if rr.Header().Rrtype != dns.TypeOPT {
+				// OPT records are not merged, so they do not need translation
 				log.Infof("Unexpected type %v", rr.Header().Rrtype)
 			}
+			return
 		}
 	}
 }
 
+// masqueradeSOA translates the owner, mname and rname of SOA record from the cluster domain into the distributed domain
+func (w *GatherResponsePrinter) masqueradeSOA(soa *dns.SOA) {
+	for _, cluster := range w.clusters {
+		if name, ok := fromCluster(soa.Hdr.Name, cluster, w.domain); ok {
+			soa.Hdr.Name = name
+			soa.Ns, _ = fromCluster(soa.Ns, cluster, w.domain)
+			soa.Mbox, _ = fromCluster(soa.Mbox, cluster, w.domain)
+			return
+		}
+	}
+}
+
+// Flush writes the merged response to the client.
 func (w *GatherResponsePrinter) Flush(r *dns.Msg) {
+	if err := w.ResponseWriter.WriteMsg(w.Response(r)); err != nil {
+		log.Errorf(
+			"error occurred while writing response: question=%v, error=%s", w.originalQuestion, err,
+		)
+	}
+}
+
+// Response returns the response merged from all contributions.
+func (w *GatherResponsePrinter) Response(r *dns.Msg) *dns.Msg {
 	w.lockCh <- true
 	defer func() {
 		<-w.lockCh
@@ -274,25 +855,34 @@ func (w *GatherResponsePrinter) Flush(r *dns.Msg) {
 		response = new(dns.Msg)
 		response.SetReply(r)
 		response.Rcode = dns.RcodeServerFailure
-		response = response.SetEdns0(4096, true)
-		response.IsEdns0().Option = append(
-			response.IsEdns0().Option,
-			&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNetworkError, ExtraText: "Sub-queries canceled due to timeout"},
+		addExtendedError(response, dns.ExtendedErrorCodeNetworkError, "Sub-queries canceled due to timeout")
+	} else if len(w.stale) > 0 {
+		addExtendedError(
+			response,
+			dns.ExtendedErrorCodeStaleAnswer,
+			fmt.Sprintf("Stale records of clusters: %s", strings.Join(w.stale, ", ")),
 		)
 	}
-	if err := w.ResponseWriter.WriteMsg(response); err != nil {
-		log.Errorf(
-			"error occurred while writing response: question=%v, error=%s", w.originalQuestion, err,
-		)
+	if w.state != nil {
+		sortServiceBindings(response.Answer)
+	}
+	if w.state != nil && w.txtPolicy.gathered() {
+		w.txtPolicy.apply(response, w.txtOrder, w.txt)
+	}
+	if w.state != nil && w.authority != nil && isNegative(response) && dns.IsSubDomain(w.domain, w.originalQuestion.Name) {
+		if soa := w.authority.SOA(w.soas); soa != nil {
+			response.Ns = []dns.RR{soa}
+		}
 	}
 	w.shortMessage()
+	return response
 }
 
 func (w *GatherResponsePrinter) shortMessage() {
 	if w.state != nil {
 		questionType := dns.Type(w.state.Question[0].Qtype).String()
 		log.Infof(
-			"type=%s, question=%s, response=%s, answer-records=%d, extra-records=%d, gathered=%d, not-gatherer=%d, duration=%s",
+			"type=%s, question=%s, response=%s, answer-records=%d, extra-records=%d, gathered=%d, not-gatherer=%d, timed-out=%v, skipped=%v, stale=%v, duration=%s",
 			questionType,
 			w.state.Question[0].Name,
 			strings.Split(w.state.MsgHdr.String(), "\n")[0],
@@ -300,26 +890,29 @@ func (w *GatherResponsePrinter) shortMessage() {
 			len(w.state.Extra),
 			len(w.clusters)-w.counter,
 			w.counter,
+			w.timedOut,
+			w.skipped,
+			w.stale,
 			time.Since(w.start),
 		)
 	} else {
 		log.Errorf(
-			"response printer has an empty state - SERVFAIL returned, original question was: %v", w.originalQuestion,
+			"response printer has an empty state - SERVFAIL returned, original question was: %v, timed-out=%v, skipped=%v",
+			w.originalQuestion,
+			w.timedOut,
+			w.skipped,
 		)
 	}
 }
 
-func divideDomain(domain string) (string, string) {
-	// TODO: move to util
-	protocolPrefix := ""
-	for _, element := range strings.Split(domain, ".") {
-		if strings.HasPrefix(element, "_") {
-			protocolPrefix = protocolPrefix + element + "."
-		} else {
-			break
-		}
+// addExtendedError attaches extended DNS error (RFC 8914) to the response
+func addExtendedError(response *dns.Msg, code uint16, text string) {
+	opt := response.IsEdns0()
+	if opt == nil {
+		response.SetEdns0(4096, true)
+		opt = response.IsEdns0()
 	}
-	return protocolPrefix, strings.TrimPrefix(domain, protocolPrefix)
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
 }
 
 func IsProxyType(questionType uint16) bool {
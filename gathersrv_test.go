@@ -8,6 +8,7 @@ import (
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 type Assertion struct {
@@ -45,6 +46,23 @@ func TestShouldNotProxyUnqualifiedRequests(t *testing.T) {
 	}
 }
 
+func TestShouldProxyOnlyConfiguredTypes(t *testing.T) {
+	gatherPlugin := &GatherSrv{
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+	}
+	for _, qtype := range []uint16{dns.TypeSRV, dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeSVCB, dns.TypeHTTPS} {
+		require.True(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: qtype}))
+	}
+	require.False(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeMX}))
+
+	gatherPlugin.Types = []uint16{dns.TypeSRV, dns.TypeA}
+	require.True(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeSRV}))
+	require.True(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeA}))
+	require.False(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeTXT}))
+	require.False(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeAAAA}))
+}
+
 func TestShouldProxyQualifiedRequestsToEachConfiguredCluster(t *testing.T) {
 	qualifiedQuestions := map[string]Assertion{
 		"srv": {
@@ -261,6 +279,238 @@ func TestShouldReturnServerFailCodeWithExtendedContextIfNoSubQueryIsReadyOnFlush
 	require.Equal(t, dns.ExtendedErrorCodeNetworkError, extendedError.InfoCode)
 }
 
+func TestShouldReturnPartialResponseIfClusterTimedOut(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	expectedQuestions := map[string]Assertion{
+		"_http._tcp.demo.svc.cluster-a.local.": assertion,
+		"_http._tcp.demo.svc.cluster-b.local.": assertion,
+	}
+	answersFromCluster := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}
+	next := PrepareDelayedNextHandler(
+		PrepareContentNextHandler(expectedQuestions, answersFromCluster, map[string][]dns.RR{}),
+		map[string]time.Duration{"_http._tcp.demo.svc.cluster-b.local.": time.Second},
+	)
+	expectedAnswers := []dns.RR{
+		test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local."),
+	}
+
+	plugins := map[string]*GatherSrv{
+		"global timeout": {
+			Next:    next,
+			Domain:  "distro.local.",
+			Timeout: 50 * time.Millisecond,
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-"},
+			},
+		},
+		"cluster timeout": {
+			Next:    next,
+			Domain:  "distro.local.",
+			Timeout: 5 * time.Second,
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-", Timeout: 50 * time.Millisecond},
+			},
+		},
+	}
+	for name, gatherPlugin := range plugins {
+		start := time.Now()
+		msg := CheckAssertion(t, gatherPlugin, assertion)
+		require.Lessf(t, time.Since(start), 500*time.Millisecond, "Expected timeout to be respected: %s", name)
+		require.Equalf(t, expectedAnswers, msg.Answer, "Unexpected answer: %s", name)
+	}
+}
+
+func TestShouldReturnServerFailCodeIfAllClustersTimedOut(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeServerFailure,
+		ExpectedError: errSubRequestTimeout,
+	}
+	next := PrepareDelayedNextHandler(
+		PrepareOnlyCodeNextHandler(map[string]Assertion{}),
+		map[string]time.Duration{"_http._tcp.demo.svc.cluster-a.local.": time.Second},
+	)
+	gatherPlugin := &GatherSrv{
+		Next:     next,
+		Domain:   "distro.local.",
+		Timeout:  50 * time.Millisecond,
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	extendedError, ok := msg.IsEdns0().Option[0].(*dns.EDNS0_EDE)
+	require.True(t, ok)
+	require.Equal(t, dns.ExtendedErrorCodeNetworkError, extendedError.InfoCode)
+
+	// the written SERVFAIL response must not be followed by another one written by CoreDNS
+	code, _ := gatherPlugin.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), NewDnsMsg(assertion))
+	require.Equal(t, dns.RcodeSuccess, code)
+}
+
+func TestShouldCompleteGatheringAccordingToPolicy(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	expectedQuestions := map[string]Assertion{}
+	answersFromCluster := map[string][]dns.RR{}
+	var clusters []Cluster
+	for _, name := range []string{"a", "b", "c"} {
+		question := "_http._tcp.demo.svc.cluster-" + name + ".local."
+		expectedQuestions[question] = assertion
+		answersFromCluster[question] = []dns.RR{
+			test.SRV(question + " 30 IN SRV 0 50 8080 demo-0.svc.cluster-" + name + ".local."),
+		}
+		clusters = append(clusters, Cluster{Suffix: "cluster-" + name + ".local.", Prefix: name + "-"})
+	}
+	next := PrepareDelayedNextHandler(
+		PrepareContentNextHandler(expectedQuestions, answersFromCluster, map[string][]dns.RR{}),
+		map[string]time.Duration{
+			"_http._tcp.demo.svc.cluster-b.local.": 50 * time.Millisecond,
+			"_http._tcp.demo.svc.cluster-c.local.": time.Second,
+		},
+	)
+	quorum, _ := NewGatherPolicy("quorum", 0, 0)
+	first, _ := NewGatherPolicy("first", 1, 0)
+	firstWithGrace, _ := NewGatherPolicy("first", 1, 200*time.Millisecond)
+	expectations := map[GatherPolicy][]string{
+		quorum:         {"a-demo-0.svc.distro.local.", "b-demo-0.svc.distro.local."},
+		first:          {"a-demo-0.svc.distro.local."},
+		firstWithGrace: {"a-demo-0.svc.distro.local.", "b-demo-0.svc.distro.local."},
+	}
+
+	for policy, expectedTargets := range expectations {
+		gatherPlugin := &GatherSrv{Next: next, Domain: "distro.local.", Clusters: clusters, Policy: policy}
+		start := time.Now()
+		msg := CheckAssertion(t, gatherPlugin, assertion)
+		require.Lessf(t, time.Since(start), 500*time.Millisecond, "Expected policy to be respected: %v", policy)
+		var targets []string
+		for _, rr := range msg.Answer {
+			targets = append(targets, rr.(*dns.SRV).Target)
+		}
+		require.ElementsMatchf(t, expectedTargets, targets, "Unexpected answer for policy: %v", policy)
+	}
+}
+
+func TestShouldDeduplicateIdenticalRecordsOfClusters(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	expectedQuestions := map[string]Assertion{
+		"_http._tcp.demo.svc.cluster-a.local.": assertion,
+		"_http._tcp.demo.svc.cluster-b.local.": assertion,
+	}
+	answersFromClusters := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}
+	extrasFromClusters := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.A("demo-0.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+			test.A("lb.example.com. 30 IN A 192.0.2.10"),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.A("demo-0.svc.cluster-b.local. 30 IN A 10.9.1.2"),
+			test.A("lb.example.com. 10 IN A 192.0.2.10"),
+		},
+	}
+	for _, keepDuplicates := range []bool{false, true} {
+		gatherPlugin := &GatherSrv{
+			Next:   PrepareContentNextHandler(expectedQuestions, answersFromClusters, extrasFromClusters),
+			Domain: "distro.local.",
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-"},
+			},
+			KeepDuplicates: keepDuplicates,
+		}
+		msg := CheckAssertion(t, gatherPlugin, assertion)
+		require.Len(t, msg.Answer, 2)
+		var shared []dns.RR
+		for _, rr := range msg.Extra {
+			if rr.Header().Name == "lb.example.com." {
+				shared = append(shared, rr)
+			}
+		}
+		if keepDuplicates {
+			require.Len(t, msg.Extra, 4)
+			require.Len(t, shared, 2)
+		} else {
+			require.Len(t, msg.Extra, 3)
+			require.Len(t, shared, 1)
+			require.Equal(t, uint32(10), shared[0].Header().Ttl)
+		}
+	}
+}
+
+func TestShouldTranslateCNAMERecordsFromClusters(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{
+				"demo.svc.cluster-a.local.": assertion,
+				"demo.svc.cluster-b.local.": assertion,
+			},
+			map[string][]dns.RR{
+				"demo.svc.cluster-a.local.": {
+					test.CNAME("demo.svc.cluster-a.local. 30 IN CNAME backend.svc.cluster-a.local."),
+					test.A("backend.svc.cluster-a.local. 30 IN A 10.8.1.2"),
+				},
+				"demo.svc.cluster-b.local.": {
+					test.CNAME("demo.svc.cluster-b.local. 30 IN CNAME db.example.com."),
+					test.A("db.example.com. 30 IN A 192.0.2.10"),
+				},
+			},
+			map[string][]dns.RR{},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+	}
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.ElementsMatch(
+		t,
+		[]string{
+			"a-demo.svc.distro.local.\t30\tIN\tCNAME\ta-backend.svc.distro.local.",
+			"a-backend.svc.distro.local.\t30\tIN\tA\t10.8.1.2",
+			"b-demo.svc.distro.local.\t30\tIN\tCNAME\tdb.example.com.",
+			"db.example.com.\t30\tIN\tA\t192.0.2.10",
+		},
+		RecordsAsStrings(msg.Answer),
+	)
+}
+
 func PrepareOnlyCodeNextHandler(expectedQuestions map[string]Assertion) test.Handler {
 	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 		m := new(dns.Msg)
@@ -299,6 +549,15 @@ func PrepareContentNextHandler(expectedQuestions map[string]Assertion, answers m
 	})
 }
 
+func PrepareDelayedNextHandler(next test.Handler, delays map[string]time.Duration) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		if delay, ok := delays[r.Question[0].Name]; ok {
+			time.Sleep(delay)
+		}
+		return next.ServeDNS(ctx, w, r)
+	})
+}
+
 func NewDnsMsg(assertion Assertion) *dns.Msg {
 	req := new(dns.Msg)
 	req.SetQuestion(dns.Fqdn(assertion.GivenName), assertion.GivenType)
@@ -0,0 +1,166 @@
+package gathersrv
+
+import (
+	"context"
+	"fmt"
+	"github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+)
+
+type healthState int
+
+const (
+	healthUnknown healthState = iota
+	healthHealthy
+	healthUnhealthy
+)
+
+// HealthChecker periodically sends a canary query (for example kubernetes.default.svc.<cluster domain>) to each cluster.
+// Clusters which failed the check are skipped during gathering and the plugin is not ready
+// as long as fewer than the required number of clusters are healthy.
+type HealthChecker struct {
+	name       string
+	interval   time.Duration
+	timeout    time.Duration
+	minHealthy int
+
+	lock   sync.RWMutex
+	states map[string]healthState
+	stop   chan struct{}
+}
+
+// NewHealthChecker returns HealthChecker for the canary name relative to cluster domains.
+func NewHealthChecker(name string, interval, timeout time.Duration, minHealthy int) (*HealthChecker, error) {
+	name = strings.TrimSuffix(name, ".")
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		return nil, fmt.Errorf("incorrect health check name <%s>", name)
+	}
+	if interval <= 0 || timeout <= 0 {
+		return nil, fmt.Errorf("incorrect health check interval <%s> or timeout <%s>", interval, timeout)
+	}
+	if minHealthy < 0 {
+		return nil, fmt.Errorf("incorrect minimal number of healthy clusters <%d>", minHealthy)
+	}
+	return &HealthChecker{
+		name:       name,
+		interval:   interval,
+		timeout:    timeout,
+		minHealthy: minHealthy,
+		states:     map[string]healthState{},
+	}, nil
+}
+
+// Start checks clusters of the plugin periodically until Stop is called.
+func (hc *HealthChecker) Start(gatherSrv GatherSrv) {
+	hc.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(hc.interval)
+		defer ticker.Stop()
+		for {
+			hc.check(gatherSrv)
+			select {
+			case <-ticker.C:
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends periodic checks.
+func (hc *HealthChecker) Stop() {
+	if hc.stop != nil {
+		close(hc.stop)
+	}
+}
+
+// Healthy reports whether the cluster with given prefix could be used - clusters which were not checked yet are used.
+func (hc *HealthChecker) Healthy(prefix string) bool {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+	state := hc.states[prefix]
+	return state == healthUnknown || state == healthHealthy
+}
+
+// Ready reports whether enough clusters passed the last check.
+func (hc *HealthChecker) Ready() bool {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+	healthy := 0
+	for _, state := range hc.states {
+		if state == healthHealthy {
+			healthy++
+		}
+	}
+	return healthy >= hc.minHealthy
+}
+
+// check sends the canary query to all clusters in parallel and records their states
+func (hc *HealthChecker) check(gatherSrv GatherSrv) {
+	var wg sync.WaitGroup
+	for _, cluster := range gatherSrv.Clusters {
+		wg.Add(1)
+		go func(cluster Cluster) {
+			defer wg.Done()
+			hc.record(cluster.Prefix, hc.probe(gatherSrv, cluster))
+		}(cluster)
+	}
+	wg.Wait()
+}
+
+func (hc *HealthChecker) probe(gatherSrv GatherSrv, cluster Cluster) healthState {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	defer cancel()
+	request := new(dns.Msg)
+	request.SetQuestion(hc.name+"."+cluster.Suffix, dns.TypeA)
+	resp := gatherSrv.newSubRequest(cluster, request).resolve(ctx, &probeWriter{})
+	if !resp.successful() || resp.Msg.Rcode != dns.RcodeSuccess {
+		return healthUnhealthy
+	}
+	return healthHealthy
+}
+
+func (hc *HealthChecker) record(prefix string, state healthState) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if hc.states[prefix] != state {
+		if state == healthHealthy {
+			log.Infof("Cluster with prefix %s is healthy", prefix)
+		} else {
+			log.Warningf("Cluster with prefix %s is unhealthy, canary query %s failed", prefix, hc.name)
+		}
+	}
+	hc.states[prefix] = state
+}
+
+// probeWriter is a dns.ResponseWriter for queries originated by the plugin itself, responses are captured by subRequest
+type probeWriter struct{}
+
+func (pw *probeWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (pw *probeWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (pw *probeWriter) WriteMsg(*dns.Msg) error { return nil }
+
+func (pw *probeWriter) Write(buf []byte) (int, error) { return len(buf), nil }
+
+func (pw *probeWriter) Close() error { return nil }
+
+func (pw *probeWriter) TsigStatus() error { return nil }
+
+func (pw *probeWriter) TsigTimersOnly(bool) {}
+
+func (pw *probeWriter) Hijack() {}
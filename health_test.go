@@ -0,0 +1,95 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestShouldCheckHealthOfClusters(t *testing.T) {
+	ok := Assertion{GivenType: dns.TypeA, ExpectedRcode: dns.RcodeSuccess}
+	health, err := NewHealthChecker("kubernetes.default.svc", time.Hour, time.Second, 2)
+	require.NoError(t, err)
+	gatherPlugin := GatherSrv{
+		Next: PrepareOnlyCodeNextHandler(map[string]Assertion{
+			"kubernetes.default.svc.cluster-a.local.": ok,
+			"kubernetes.default.svc.cluster-b.local.": ok,
+		}),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+			{Suffix: "cluster-c.local.", Prefix: "c-"},
+		},
+		Health: health,
+	}
+	require.False(t, gatherPlugin.Ready(), "Expected not ready before the first check")
+	require.True(t, health.Healthy("c-"), "Expected unchecked cluster to be used")
+
+	health.check(gatherPlugin)
+
+	require.True(t, gatherPlugin.Ready())
+	require.True(t, health.Healthy("a-"))
+	require.True(t, health.Healthy("b-"))
+	require.False(t, health.Healthy("c-"))
+
+	health.minHealthy = 3
+	require.False(t, gatherPlugin.Ready())
+}
+
+func TestShouldSkipUnhealthyClusters(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	expectedQuestions := map[string]Assertion{
+		"_http._tcp.demo.svc.cluster-a.local.":    assertion,
+		"_http._tcp.demo.svc.cluster-b.local.":    assertion,
+		"kubernetes.default.svc.cluster-a.local.": {GivenType: dns.TypeA, ExpectedRcode: dns.RcodeSuccess},
+	}
+	answersFromCluster := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}
+	health, _ := NewHealthChecker("kubernetes.default.svc", time.Hour, time.Second, 1)
+	gatherPlugin := &GatherSrv{
+		Next:   PrepareContentNextHandler(expectedQuestions, answersFromCluster, map[string][]dns.RR{}),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Health: health,
+	}
+	health.check(*gatherPlugin)
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(
+		t,
+		[]dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		msg.Answer,
+	)
+}
+
+func TestShouldStopPeriodicHealthChecks(t *testing.T) {
+	health, _ := NewHealthChecker("kubernetes.default.svc", time.Millisecond, time.Second, 1)
+	gatherPlugin := GatherSrv{
+		Next: PrepareOnlyCodeNextHandler(map[string]Assertion{
+			"kubernetes.default.svc.cluster-a.local.": {GivenType: dns.TypeA, ExpectedRcode: dns.RcodeSuccess},
+		}),
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+		Health:   health,
+	}
+	health.Start(gatherPlugin)
+	require.Eventually(t, gatherPlugin.Ready, time.Second, time.Millisecond)
+	health.Stop()
+}
@@ -0,0 +1,96 @@
+package gathersrv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	latencyWindowSize       = 128
+	latencyWindowMinSamples = 16
+)
+
+// Hedge decides when a duplicate of a slow sub-request should be sent to the cluster.
+// The delay is either fixed or computed as a percentile of recently observed latencies of the cluster.
+type Hedge struct {
+	delay      time.Duration
+	percentile int
+	latencies  *latencyWindow
+}
+
+// NewHedge returns Hedge for the spec in form DURATION (for example 50ms) or pNN (for example p95).
+func NewHedge(spec string) (*Hedge, error) {
+	if strings.HasPrefix(spec, "p") {
+		percentile, err := strconv.Atoi(strings.TrimPrefix(spec, "p"))
+		if err != nil || percentile <= 0 || percentile >= 100 {
+			return nil, fmt.Errorf("incorrect hedge percentile <%s>", spec)
+		}
+		return &Hedge{percentile: percentile, latencies: newLatencyWindow(latencyWindowSize)}, nil
+	}
+	delay, err := time.ParseDuration(spec)
+	if err != nil || delay <= 0 {
+		return nil, fmt.Errorf("incorrect hedge delay <%s>", spec)
+	}
+	return &Hedge{delay: delay}, nil
+}
+
+// Clone returns Hedge with the same spec but with its own latency history.
+func (h *Hedge) Clone() *Hedge {
+	clone := &Hedge{delay: h.delay, percentile: h.percentile}
+	if h.latencies != nil {
+		clone.latencies = newLatencyWindow(latencyWindowSize)
+	}
+	return clone
+}
+
+// Delay returns how long to wait before sending the hedged sub-request.
+// It returns false if there are not enough latency samples to compute the percentile yet.
+func (h *Hedge) Delay() (time.Duration, bool) {
+	if h.latencies == nil {
+		return h.delay, true
+	}
+	return h.latencies.percentile(h.percentile)
+}
+
+// Observe records latency of a completed sub-request.
+func (h *Hedge) Observe(latency time.Duration) {
+	if h.latencies != nil {
+		h.latencies.add(latency)
+	}
+}
+
+type latencyWindow struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+func (lw *latencyWindow) add(latency time.Duration) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if len(lw.samples) < cap(lw.samples) {
+		lw.samples = append(lw.samples, latency)
+		return
+	}
+	lw.samples[lw.next] = latency
+	lw.next = (lw.next + 1) % len(lw.samples)
+}
+
+func (lw *latencyWindow) percentile(percentile int) (time.Duration, bool) {
+	lw.lock.Lock()
+	sorted := append([]time.Duration{}, lw.samples...)
+	lw.lock.Unlock()
+	if len(sorted) < latencyWindowMinSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)-1)*percentile/100], true
+}
@@ -0,0 +1,81 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldComputeHedgeDelayFromLatencyPercentile(t *testing.T) {
+	hedge, err := NewHedge("p90")
+	require.NoError(t, err)
+
+	_, ok := hedge.Delay()
+	require.False(t, ok, "Expected no delay without enough samples")
+
+	for i := 1; i <= 100; i++ {
+		hedge.Observe(time.Duration(i) * time.Millisecond)
+	}
+	delay, ok := hedge.Delay()
+	require.True(t, ok)
+	require.Equal(t, 90*time.Millisecond, delay)
+
+	// only the most recent samples are taken into account
+	for i := 0; i < latencyWindowSize; i++ {
+		hedge.Observe(time.Millisecond)
+	}
+	delay, _ = hedge.Delay()
+	require.Equal(t, time.Millisecond, delay)
+}
+
+func TestShouldUseFixedHedgeDelay(t *testing.T) {
+	hedge, err := NewHedge("25ms")
+	require.NoError(t, err)
+	hedge.Observe(time.Second)
+	delay, ok := hedge.Delay()
+	require.True(t, ok)
+	require.Equal(t, 25*time.Millisecond, delay)
+}
+
+func TestShouldUseFirstResponseOfHedgedSubRequest(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	content := PrepareContentNextHandler(
+		map[string]Assertion{"_http._tcp.demo.svc.cluster-a.local.": assertion},
+		map[string][]dns.RR{"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		}},
+		map[string][]dns.RR{},
+	)
+	var calls int32
+	next := test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(time.Second)
+		}
+		return content.ServeDNS(ctx, w, r)
+	})
+	hedge, _ := NewHedge("20ms")
+	gatherPlugin := &GatherSrv{
+		Next:     next,
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-", Hedge: hedge}},
+	}
+
+	start := time.Now()
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Equal(
+		t,
+		[]dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		msg.Answer,
+	)
+}
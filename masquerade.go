@@ -0,0 +1,80 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+)
+
+// masquerader translates names of the record returned by the cluster into the distributed domain,
+// head and tail are the protocol labels and the remaining part of the owner name already moved into the distributed domain
+type masquerader func(rr dns.RR, cluster Cluster, domain string, head string, tail string)
+
+// masqueraders lists record types which could be translated, only these types could be gathered
+var masqueraders = map[uint16]masquerader{
+	dns.TypeSRV: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		srvRecord := rr.(*dns.SRV)
+		srvRecord.Header().Name = head + tail
+		srvRecord.Target = translateTarget(srvRecord.Target, cluster, domain)
+	},
+	dns.TypeA:    masqueradeAddress,
+	dns.TypeAAAA: masqueradeAddress,
+	dns.TypeTXT: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		rr.Header().Name = head + tail
+	},
+	dns.TypeSVCB:  masqueradeServiceBinding,
+	dns.TypeHTTPS: masqueradeServiceBinding,
+	dns.TypeCNAME: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		cnameRecord := rr.(*dns.CNAME)
+		cnameRecord.Header().Name = fmt.Sprintf("%s%s%s", head, cluster.Prefix, tail)
+		// targets outside the cluster domain (e.g. ExternalName services) are left untouched
+		cnameRecord.Target = translateTarget(cnameRecord.Target, cluster, domain)
+	},
+	dns.TypeMX: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		mxRecord := rr.(*dns.MX)
+		mxRecord.Header().Name = head + tail
+		mxRecord.Mx = translateTarget(mxRecord.Mx, cluster, domain)
+	},
+	dns.TypeNS: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		nsRecord := rr.(*dns.NS)
+		nsRecord.Header().Name = head + tail
+		nsRecord.Ns = translateTarget(nsRecord.Ns, cluster, domain)
+	},
+	dns.TypePTR: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		// owner of PTR record is a reverse name, only the target belongs to the cluster domain
+		ptrRecord := rr.(*dns.PTR)
+		ptrRecord.Ptr = translateTarget(ptrRecord.Ptr, cluster, domain)
+	},
+	dns.TypeNAPTR: func(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+		naptrRecord := rr.(*dns.NAPTR)
+		naptrRecord.Header().Name = head + tail
+		// "." replacement means that the regexp field is used instead
+		naptrRecord.Replacement = translateTarget(naptrRecord.Replacement, cluster, domain)
+	},
+}
+
+func masqueradeAddress(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+	rr.Header().Name = fmt.Sprintf("%s%s%s", head, cluster.Prefix, tail)
+}
+
+func masqueradeServiceBinding(rr dns.RR, cluster Cluster, domain string, head string, tail string) {
+	svcbRecord := svcbOf(rr)
+	svcbRecord.Header().Name = head + tail
+	// "." target means the owner name, targets outside the cluster domain are left untouched
+	svcbRecord.Target = translateTarget(svcbRecord.Target, cluster, domain)
+}
+
+// translateTarget moves the target name from the cluster domain into the distributed domain with the cluster prefix,
+// names outside the cluster domain are returned unchanged
+func translateTarget(target string, cluster Cluster, domain string) string {
+	translated, ok := fromCluster(target, cluster, domain)
+	if !ok {
+		return target
+	}
+	return addPrefix(translated, cluster.Prefix)
+}
+
+// IsMasqueradedType reports whether records of the type could be translated into the distributed domain
+func IsMasqueradedType(rrtype uint16) bool {
+	_, ok := masqueraders[rrtype]
+	return ok
+}
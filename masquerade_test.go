@@ -0,0 +1,36 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShouldTranslateNamesInsideRdata(t *testing.T) {
+	printer := NewResponsePrinter(
+		&test.ResponseWriter{},
+		new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeMX),
+		"distro.local.",
+		[]Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}, {Suffix: "cluster-b.local.", Prefix: "b-"}},
+		1,
+	)
+	expectations := map[string]string{
+		"demo.svc.cluster-a.local. 30 IN MX 10 mail.svc.cluster-a.local.":                                         "demo.svc.distro.local.\t30\tIN\tMX\t10 a-mail.svc.distro.local.",
+		"demo.svc.cluster-b.local. 30 IN MX 10 mail.example.com.":                                                 "demo.svc.distro.local.\t30\tIN\tMX\t10 mail.example.com.",
+		"svc.cluster-a.local. 30 IN NS ns.dns.cluster-a.local.":                                                   "svc.distro.local.\t30\tIN\tNS\ta-ns.dns.distro.local.",
+		"demo.svc.cluster-b.local. 30 IN NAPTR 100 10 \"S\" \"SIP+D2U\" \"\" _sip._udp.demo.svc.cluster-b.local.": "demo.svc.distro.local.\t30\tIN\tNAPTR\t100 10 \"S\" \"SIP+D2U\" \"\" _sip._udp.b-demo.svc.distro.local.",
+		"demo.svc.cluster-a.local. 30 IN NAPTR 100 10 \"U\" \"E2U+sip\" \"!^.*$!sip:info@example.com!\" .":        "demo.svc.distro.local.\t30\tIN\tNAPTR\t100 10 \"U\" \"E2U+sip\" \"!^.*$!sip:info@example.com!\" .",
+	}
+	for record, expected := range expectations {
+		rr, err := dns.NewRR(record)
+		require.NoError(t, err)
+		printer.Masquerade(rr)
+		require.Equal(t, expected, rr.String())
+	}
+
+	for _, rrtype := range []uint16{dns.TypeMX, dns.TypeNS, dns.TypeNAPTR, dns.TypePTR} {
+		require.True(t, IsMasqueradedType(rrtype))
+	}
+	require.False(t, IsMasqueradedType(dns.TypeDNSKEY))
+}
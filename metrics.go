@@ -20,3 +20,52 @@ var subRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name:      "sub_request_count_total",
 	Help:      "Counter of sub requests.",
 }, []string{"server", "prefix", "type", "code"})
+
+var subRequestTimeoutCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "sub_request_timeout_count_total",
+	Help:      "Counter of sub requests which have timed out.",
+}, []string{"server", "prefix", "type"})
+
+var subRequestHedgeCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "sub_request_hedge_count_total",
+	Help:      "Counter of hedged sub requests.",
+}, []string{"server", "prefix", "type"})
+
+var subRequestRetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "sub_request_retry_count_total",
+	Help:      "Counter of retried sub requests.",
+}, []string{"server", "prefix", "type"})
+
+var clusterBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "cluster_breaker_state",
+	Help:      "State of the cluster circuit breaker: 0 - closed, 1 - half-open, 2 - open.",
+}, []string{"server", "prefix"})
+
+var cacheRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "cache_request_count_total",
+	Help:      "Counter of cache lookups by result: hit, refresh (some clusters queried) or miss.",
+}, []string{"server", "type", "result"})
+
+var coalescedRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "coalesced_request_count_total",
+	Help:      "Counter of requests answered with the merged response of a concurrent identical request.",
+}, []string{"server", "type"})
+
+var droppedRecordCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: gatherSrvPluginName,
+	Name:      "dropped_record_count_total",
+	Help:      "Counter of records returned by clusters outside their domains and dropped in strict bailiwick mode.",
+}, []string{"server", "prefix", "type"})
@@ -0,0 +1,67 @@
+package gathersrv
+
+import (
+	"github.com/miekg/dns"
+	"strings"
+)
+
+// replaceZone moves the name from zone into another zone keeping labels above the zone,
+// false is returned if the name does not belong to the zone. Names are compared label by label, so neither the text
+// of the zone appearing earlier in the name nor the zone matching in the middle of a label (xcluster-a.local.) is replaced.
+func replaceZone(name, from, to string) (string, bool) {
+	if !dns.IsSubDomain(from, name) {
+		return name, false
+	}
+	labels := dns.SplitDomainName(name)
+	head := labels[:len(labels)-dns.CountLabel(from)]
+	if len(head) == 0 {
+		return to, true
+	}
+	if to == "." {
+		return strings.Join(head, ".") + ".", true
+	}
+	return strings.Join(head, ".") + "." + to, true
+}
+
+// divideDomain splits the name into leading protocol labels (starting with underscore) and the remaining part of the name
+func divideDomain(domain string) (string, string) {
+	labels := dns.SplitDomainName(domain)
+	offsets := dns.Split(domain)
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "_") {
+			return domain[:offsets[i]], domain[offsets[i]:]
+		}
+	}
+	return domain, ""
+}
+
+// addPrefix puts the cluster prefix in front of the first label which is not a protocol label
+func addPrefix(name, prefix string) string {
+	head, tail := divideDomain(name)
+	return head + prefix + tail
+}
+
+// stripPrefix removes the cluster prefix from the first label which is not a protocol label,
+// false is returned if the label does not start with the prefix or the label belongs to the zone itself
+func stripPrefix(name, prefix, zone string) (string, bool) {
+	head, tail := divideDomain(name)
+	if !strings.HasPrefix(tail, prefix) || dns.CountLabel(tail) <= dns.CountLabel(zone) {
+		return name, false
+	}
+	stripped := strings.TrimPrefix(tail, prefix)
+	if strings.HasPrefix(stripped, ".") {
+		// the prefix was the whole label
+		return name, false
+	}
+	return head + stripped, true
+}
+
+// toCluster translates the name from the distributed domain into the cluster domain
+func toCluster(name string, cluster Cluster, domain string) (string, bool) {
+	return replaceZone(name, domain, cluster.Suffix)
+}
+
+// fromCluster translates the name from the cluster domain into the distributed domain without adding the cluster prefix
+func fromCluster(name string, cluster Cluster, domain string) (string, bool) {
+	return replaceZone(name, cluster.Suffix, domain)
+}
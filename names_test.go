@@ -0,0 +1,115 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+var namesDomain = "distro.local."
+
+var namesClusters = []Cluster{
+	{Suffix: "cluster-a.local.", Prefix: "a-"},
+	{Suffix: "dc.cluster-b.local.", Prefix: "b-"},
+}
+
+// labelsPool contains texts of zones used in tests, so generated names contain them outside their proper position
+var labelsPool = []string{"demo", "svc", "default", "local", "distro", "cluster-a", "xcluster-a", "dc", "cluster-b", "a-", "b-x"}
+
+// relativeName is a sequence of labels placed in front of a zone
+type relativeName []string
+
+func (relativeName) Generate(rand *rand.Rand, size int) reflect.Value {
+	var labels []string
+	// protocol labels are placed only in front of the name, like in SRV questions
+	if rand.Intn(2) == 0 {
+		labels = append(labels, "_http", "_tcp")
+	}
+	labels = append(labels, "demo")
+	for i := rand.Intn(4); i > 0; i-- {
+		labels = append(labels, labelsPool[rand.Intn(len(labelsPool))])
+	}
+	return reflect.ValueOf(relativeName(labels))
+}
+
+func (n relativeName) in(zone string) string {
+	return strings.Join(n, ".") + "." + zone
+}
+
+func TestShouldRoundTripNamesOfClusters(t *testing.T) {
+	gatherSrv := GatherSrv{Domain: namesDomain, Clusters: namesClusters}
+	for _, cluster := range namesClusters {
+		property := func(name relativeName) bool {
+			// the record returned by the cluster is masqueraded into the name which leads back to the same record
+			record := test.A(name.in(cluster.Suffix) + " 30 IN A 10.8.1.2")
+			pw := NewResponsePrinter(nil, new(dns.Msg).SetQuestion(namesDomain, dns.TypeA), namesDomain, namesClusters, 1)
+			pw.Masquerade(record)
+			if !gatherSrv.IsQualifiedQuestion(dns.Question{Name: record.Header().Name, Qtype: dns.TypeA}) {
+				return false
+			}
+			calls := gatherSrv.prepareSubRequests(new(dns.Msg).SetQuestion(record.Header().Name, dns.TypeA))
+			return len(calls) == 1 && calls[0].prefix == cluster.Prefix && calls[0].request.Question[0].Name == name.in(cluster.Suffix)
+		}
+		require.NoError(t, quick.Check(property, nil), "Expected round trip through cluster %s", cluster.Prefix)
+	}
+}
+
+func TestShouldTranslateQuestionsIntoEachCluster(t *testing.T) {
+	gatherSrv := GatherSrv{Domain: namesDomain, Clusters: namesClusters}
+	property := func(name relativeName) bool {
+		// generated names do not start with any cluster prefix, so the question is sent to all clusters
+		calls := gatherSrv.prepareSubRequests(new(dns.Msg).SetQuestion(name.in(namesDomain), dns.TypeA))
+		if len(calls) != len(namesClusters) {
+			return false
+		}
+		for i, call := range calls {
+			if call.request.Question[0].Name != name.in(namesClusters[i].Suffix) {
+				return false
+			}
+		}
+		return true
+	}
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestShouldNotTranslateNamesMatchingZoneMidLabel(t *testing.T) {
+	property := func(name relativeName) bool {
+		for _, cluster := range namesClusters {
+			target := name.in("x" + cluster.Suffix)
+			if translateTarget(target, cluster, namesDomain) != target {
+				return false
+			}
+		}
+		_, ok := replaceZone(name.in("x"+namesDomain), namesDomain, "cluster-a.local.")
+		return !ok
+	}
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestShouldTranslateNamesLabelByLabel(t *testing.T) {
+	cluster := namesClusters[0]
+	for name, expected := range map[string]string{
+		"demo.svc.cluster-a.local.":                   "a-demo.svc.distro.local.",
+		"DEMO.svc.Cluster-A.local.":                   "a-DEMO.svc.distro.local.",
+		"cluster-a.local.svc.cluster-a.local.":        "a-cluster-a.local.svc.distro.local.",
+		"_http._tcp.demo.svc.cluster-a.local.":        "_http._tcp.a-demo.svc.distro.local.",
+		"demo.svc.xcluster-a.local.":                  "demo.svc.xcluster-a.local.",
+		"demo.cluster-a.local.example.com.":           "demo.cluster-a.local.example.com.",
+		"demo\\.svc.cluster-a.local.":                 "a-demo\\.svc.distro.local.",
+		"_http._tcp.demo\\._tcp.svc.cluster-a.local.": "_http._tcp.a-demo\\._tcp.svc.distro.local.",
+	} {
+		require.Equal(t, expected, translateTarget(name, cluster, namesDomain), "Unexpected translation of %s", name)
+	}
+
+	gatherSrv := GatherSrv{Domain: namesDomain, Clusters: namesClusters, Types: []uint16{dns.TypeA}}
+	require.False(t, gatherSrv.IsQualifiedQuestion(dns.Question{Name: "demo.xdistro.local.", Qtype: dns.TypeA}))
+	require.False(t, gatherSrv.IsQualifiedQuestion(dns.Question{Name: "demo.distro.local.example.com.", Qtype: dns.TypeA}))
+	calls := gatherSrv.prepareSubRequests(new(dns.Msg).SetQuestion("a-distro.local.svc.distro.local.", dns.TypeA))
+	require.Len(t, calls, 1)
+	require.Equal(t, "distro.local.svc.cluster-a.local.", calls[0].request.Question[0].Name)
+}
@@ -0,0 +1,86 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+// outcome classifies a sub-response for the purpose of merging response codes
+type outcome int
+
+const (
+	outcomeData outcome = iota
+	outcomeNoData
+	outcomeNameError
+	outcomeFailure
+)
+
+var outcomeNames = map[string]outcome{
+	"NOERROR":  outcomeData,
+	"NODATA":   outcomeNoData,
+	"NXDOMAIN": outcomeNameError,
+	"SERVFAIL": outcomeFailure,
+}
+
+var outcomeRcodes = map[outcome]int{
+	outcomeData:      dns.RcodeSuccess,
+	outcomeNoData:    dns.RcodeSuccess,
+	outcomeNameError: dns.RcodeNameError,
+	outcomeFailure:   dns.RcodeServerFailure,
+}
+
+// defaultRcodePrecedence makes NXDOMAIN the merged rcode only if all gathered sub-responses were NXDOMAIN
+var defaultRcodePrecedence = &RcodePrecedence{ranks: map[outcome]int{
+	outcomeData:      0,
+	outcomeNoData:    1,
+	outcomeFailure:   2,
+	outcomeNameError: 3,
+}}
+
+// RcodePrecedence decides which sub-response determines the merged response code.
+// Sub-responses are classified as NOERROR (with answers), NODATA (NOERROR without answers), NXDOMAIN or SERVFAIL
+// (errors and all remaining rcodes), the class listed earlier wins.
+type RcodePrecedence struct {
+	ranks map[outcome]int
+}
+
+// NewRcodePrecedence returns RcodePrecedence for the order of NOERROR, NODATA, NXDOMAIN and SERVFAIL, each has to be listed once.
+func NewRcodePrecedence(order []string) (*RcodePrecedence, error) {
+	ranks := map[outcome]int{}
+	for rank, name := range order {
+		class, ok := outcomeNames[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("incorrect rcode class <%s>", name)
+		}
+		if _, ok := ranks[class]; ok {
+			return nil, fmt.Errorf("rcode class <%s> listed more than once", name)
+		}
+		ranks[class] = rank
+	}
+	if len(ranks) != len(outcomeNames) {
+		return nil, fmt.Errorf("rcode precedence has to list all of NOERROR, NODATA, NXDOMAIN and SERVFAIL")
+	}
+	return &RcodePrecedence{ranks: ranks}, nil
+}
+
+// prevails reports whether the subsequent class should determine the merged response code instead of the current one
+func (rp *RcodePrecedence) prevails(current, subsequent outcome) bool {
+	return rp.ranks[subsequent] < rp.ranks[current]
+}
+
+// classify returns the class of the sub-response
+func classify(nr *NextResp) outcome {
+	switch {
+	case nr.Err != nil || nr.Msg == nil:
+		return outcomeFailure
+	case nr.Msg.Rcode == dns.RcodeSuccess && len(nr.Msg.Answer) > 0:
+		return outcomeData
+	case nr.Msg.Rcode == dns.RcodeSuccess:
+		return outcomeNoData
+	case nr.Msg.Rcode == dns.RcodeNameError:
+		return outcomeNameError
+	default:
+		return outcomeFailure
+	}
+}
@@ -0,0 +1,130 @@
+package gathersrv
+
+import (
+	"context"
+	"errors"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// clusterOutcomes lists kinds of sub-responses which could be returned by a cluster
+var clusterOutcomes = []string{"data", "nodata", "nxdomain", "servfail", "refused", "error"}
+
+func PrepareOutcomeNextHandler(outcomes map[string]string) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		switch outcomes[r.Question[0].Name] {
+		case "data":
+			m.SetRcode(r, dns.RcodeSuccess)
+			m.Answer = []dns.RR{test.A(r.Question[0].Name + " 30 IN A 10.8.1.2")}
+		case "nodata":
+			m.SetRcode(r, dns.RcodeSuccess)
+		case "nxdomain":
+			m.SetRcode(r, dns.RcodeNameError)
+		case "servfail":
+			m.SetRcode(r, dns.RcodeServerFailure)
+		case "refused":
+			m.SetRcode(r, dns.RcodeRefused)
+		default:
+			return dns.RcodeServerFailure, errors.New("network error")
+		}
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return m.Rcode, nil
+	})
+}
+
+func expectedMergedRcode(outcomes ...string) int {
+	counts := map[string]int{}
+	for _, outcome := range outcomes {
+		counts[outcome]++
+	}
+	switch {
+	case counts["data"] > 0 || counts["nodata"] > 0:
+		return dns.RcodeSuccess
+	case counts["nxdomain"] == len(outcomes):
+		return dns.RcodeNameError
+	default:
+		return dns.RcodeServerFailure
+	}
+}
+
+func TestShouldMergeRcodesOfEveryCombinationOfClusters(t *testing.T) {
+	clusters := []Cluster{
+		{Suffix: "cluster-a.local.", Prefix: "a-"},
+		{Suffix: "cluster-b.local.", Prefix: "b-"},
+		{Suffix: "cluster-c.local.", Prefix: "c-"},
+	}
+	for _, first := range clusterOutcomes {
+		for _, second := range clusterOutcomes {
+			for _, third := range clusterOutcomes {
+				gatherPlugin := &GatherSrv{
+					Next: PrepareOutcomeNextHandler(map[string]string{
+						"demo.svc.cluster-a.local.": first,
+						"demo.svc.cluster-b.local.": second,
+						"demo.svc.cluster-c.local.": third,
+					}),
+					Domain:   "distro.local.",
+					Clusters: clusters,
+				}
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				code, err := gatherPlugin.ServeDNS(
+					context.TODO(), rec, new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA),
+				)
+				expected := expectedMergedRcode(first, second, third)
+				require.Equalf(t, dns.RcodeSuccess, code, "Unexpected code for: %s, %s, %s", first, second, third)
+				require.Equalf(t, expected, rec.Msg.Rcode, "Unexpected rcode for: %s, %s, %s", first, second, third)
+				if expected != dns.RcodeServerFailure {
+					require.NoErrorf(t, err, "Unexpected error for: %s, %s, %s", first, second, third)
+				}
+			}
+		}
+	}
+}
+
+func TestShouldMergeRcodesAccordingToCustomPrecedence(t *testing.T) {
+	precedence, err := NewRcodePrecedence([]string{"NOERROR", "NXDOMAIN", "NODATA", "SERVFAIL"})
+	require.NoError(t, err)
+	expectations := map[[2]string]int{
+		{"nxdomain", "nodata"}:   dns.RcodeNameError,
+		{"nxdomain", "servfail"}: dns.RcodeNameError,
+		{"nodata", "servfail"}:   dns.RcodeSuccess,
+		{"nxdomain", "data"}:     dns.RcodeSuccess,
+		{"servfail", "refused"}:  dns.RcodeServerFailure,
+	}
+	for outcomes, expected := range expectations {
+		gatherPlugin := &GatherSrv{
+			Next: PrepareOutcomeNextHandler(map[string]string{
+				"demo.svc.cluster-a.local.": outcomes[0],
+				"demo.svc.cluster-b.local.": outcomes[1],
+			}),
+			Domain: "distro.local.",
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-"},
+			},
+			Precedence: precedence,
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, _ := gatherPlugin.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA))
+		require.Equalf(t, dns.RcodeSuccess, code, "Unexpected code for: %v", outcomes)
+		require.Equalf(t, expected, rec.Msg.Rcode, "Unexpected rcode for: %v", outcomes)
+	}
+}
+
+func TestShouldFailIfRcodePrecedenceIsIncorrect(t *testing.T) {
+	for _, order := range [][]string{
+		{},
+		{"NOERROR", "NODATA", "NXDOMAIN"},
+		{"NOERROR", "NODATA", "NXDOMAIN", "REFUSED"},
+		{"NOERROR", "NODATA", "NXDOMAIN", "NXDOMAIN"},
+		{"NOERROR", "NODATA", "NXDOMAIN", "SERVFAIL", "SERVFAIL"},
+	} {
+		_, err := NewRcodePrecedence(order)
+		require.Errorf(t, err, "Expected error for: %v", order)
+	}
+}
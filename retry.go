@@ -0,0 +1,58 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"time"
+)
+
+var defaultRetryableRcodes = []int{dns.RcodeServerFailure}
+
+// RetryPolicy decides whether a failed sub-request should be sent to the cluster again.
+// Sub-requests which ended with an error or truncated response are always retried, responses are retried only if their rcode is listed.
+type RetryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	rcodes   []int
+}
+
+// NewRetryPolicy returns RetryPolicy allowing up to attempts tries with exponential backoff between them.
+// By default, only SERVFAIL responses are retried.
+func NewRetryPolicy(attempts int, backoff time.Duration, rcodes []string) (*RetryPolicy, error) {
+	if attempts < 1 {
+		return nil, fmt.Errorf("incorrect number of retry attempts <%d>", attempts)
+	}
+	policy := &RetryPolicy{attempts: attempts, backoff: backoff, rcodes: defaultRetryableRcodes}
+	if len(rcodes) > 0 {
+		policy.rcodes = nil
+		for _, name := range rcodes {
+			rcode, ok := dns.StringToRcode[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown rcode <%s>", name)
+			}
+			policy.rcodes = append(policy.rcodes, rcode)
+		}
+	}
+	return policy, nil
+}
+
+// retryable reports whether the sub-request should be repeated after given attempt
+func (rp *RetryPolicy) retryable(resp *NextResp, attempt int) bool {
+	if attempt >= rp.attempts {
+		return false
+	}
+	if resp.Err != nil || resp.Msg == nil || resp.Msg.Truncated {
+		return true
+	}
+	for _, rcode := range rp.rcodes {
+		if resp.Msg.Rcode == rcode {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns backoff before the next attempt, it doubles with each attempt
+func (rp *RetryPolicy) delay(attempt int) time.Duration {
+	return rp.backoff << (attempt - 1)
+}
@@ -0,0 +1,69 @@
+package gathersrv
+
+import (
+	"context"
+	"errors"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldDecideWhetherSubRequestIsRetryable(t *testing.T) {
+	policy, err := NewRetryPolicy(2, 10*time.Millisecond, []string{"REFUSED"})
+	require.NoError(t, err)
+
+	refused := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeRefused}}
+	serverFailure := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}
+	require.True(t, policy.retryable(&NextResp{Msg: refused}, 1))
+	require.True(t, policy.retryable(&NextResp{Err: errors.New("network error")}, 1))
+	require.False(t, policy.retryable(&NextResp{Msg: serverFailure}, 1))
+	require.False(t, policy.retryable(&NextResp{Msg: refused}, 2))
+
+	require.Equal(t, 10*time.Millisecond, policy.delay(1))
+	require.Equal(t, 20*time.Millisecond, policy.delay(2))
+}
+
+func TestShouldRetryFailedSubRequest(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	content := PrepareContentNextHandler(
+		map[string]Assertion{"_http._tcp.demo.svc.cluster-a.local.": assertion},
+		map[string][]dns.RR{"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		}},
+		map[string][]dns.RR{},
+	)
+	var calls int32
+	next := test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			return dns.RcodeServerFailure, errors.New("network error")
+		case 2:
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			return dns.RcodeSuccess, w.WriteMsg(m)
+		}
+		return content.ServeDNS(ctx, w, r)
+	})
+	retry, _ := NewRetryPolicy(3, time.Millisecond, nil)
+	gatherPlugin := &GatherSrv{
+		Next:     next,
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-", Retry: retry}},
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Equal(
+		t,
+		[]dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		msg.Answer,
+	)
+}
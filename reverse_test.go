@@ -0,0 +1,71 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShouldTranslateTargetsOfReverseLookups(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "2.1.8.10.in-addr.arpa.",
+		GivenType:     dns.TypePTR,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	var asked []string
+	content := PrepareContentNextHandler(
+		map[string]Assertion{"2.1.8.10.in-addr.arpa.": assertion},
+		map[string][]dns.RR{
+			"2.1.8.10.in-addr.arpa.": {test.PTR("2.1.8.10.in-addr.arpa. 30 IN PTR demo-0.default.svc.cluster-a.local.")},
+		},
+		map[string][]dns.RR{},
+	)
+	gatherPlugin := &GatherSrv{
+		Next: test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			asked = append(asked, r.Question[0].Name)
+			return content.ServeDNS(ctx, w, r)
+		}),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", ReverseZones: []string{"8.10.in-addr.arpa."}},
+			{Suffix: "cluster-b.local.", Prefix: "b-", ReverseZones: []string{"9.10.in-addr.arpa."}},
+		},
+	}
+	require.True(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "2.1.8.10.in-addr.arpa.", Qtype: dns.TypePTR}))
+	require.False(t, gatherPlugin.IsQualifiedQuestion(dns.Question{Name: "2.1.7.10.in-addr.arpa.", Qtype: dns.TypePTR}))
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(
+		t,
+		[]string{"2.1.8.10.in-addr.arpa.\t30\tIN\tPTR\ta-demo-0.default.svc.distro.local."},
+		RecordsAsStrings(msg.Answer),
+	)
+	require.Equal(t, []string{"2.1.8.10.in-addr.arpa."}, asked, "Expected only the owning cluster to be asked")
+}
+
+func TestShouldPassReverseLookupsOutsideOfClusterZones(t *testing.T) {
+	gatherPlugin := &GatherSrv{
+		Next:   test.NextHandler(dns.RcodeNameError, nil),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", ReverseZones: []string{"8.10.in-addr.arpa."}},
+		},
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	code, err := gatherPlugin.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("1.0.0.127.in-addr.arpa.", dns.TypePTR))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNameError, code)
+}
+
+func TestShouldGatherReverseLookupsOnlyIfPTRIsProxied(t *testing.T) {
+	question := dns.Question{Name: "2.1.8.10.in-addr.arpa.", Qtype: dns.TypePTR}
+	clusters := []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-", ReverseZones: []string{"8.10.in-addr.arpa."}}}
+
+	require.True(t, GatherSrv{Domain: "distro.local.", Clusters: clusters}.IsQualifiedQuestion(question))
+	require.True(t, GatherSrv{Domain: "distro.local.", Clusters: clusters, Types: []uint16{dns.TypeA, dns.TypePTR}}.IsQualifiedQuestion(question))
+	require.False(t, GatherSrv{Domain: "distro.local.", Clusters: clusters, Types: []uint16{dns.TypeA}}.IsQualifiedQuestion(question))
+}
@@ -5,7 +5,12 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/miekg/dns"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const gatherSrvPluginName = "gathersrv"
@@ -14,43 +19,633 @@ const gatherSrvPluginName = "gathersrv"
 func init() { plugin.Register(gatherSrvPluginName, setup) }
 
 func setup(c *caddy.Controller) error {
-	var clusters []Cluster
+	gatherSrv, err := parse(c)
+	if err != nil {
+		return plugin.Error(gatherSrvPluginName, err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		gatherSrv.Next = next
+		return gatherSrv
+	})
+
+	if gatherSrv.Health != nil {
+		c.OnStartup(func() error {
+			gatherSrv.Health.Start(gatherSrv)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			gatherSrv.Health.Stop()
+			return nil
+		})
+	}
+
+	return nil
+}
+
+func parse(c *caddy.Controller) (GatherSrv, error) {
+	gatherSrv := GatherSrv{}
+	var hedge *Hedge
+	var retry *RetryPolicy
+	var breaker *CircuitBreaker
+	minHealthy := -1
 
 	c.Next() // Ignore "gathersrv" and give us the next token.
 	if !c.NextArg() {
-		return plugin.Error(gatherSrvPluginName, c.ArgErr())
+		return gatherSrv, c.ArgErr()
 	}
-	domain := parseDomain(c.Val())
-	if domain == "" {
-		return plugin.Error(gatherSrvPluginName, fmt.Errorf("Provided incorrect domain <%s>", c.Val()))
+	gatherSrv.Domain = parseDomain(c.Val())
+	if gatherSrv.Domain == "" {
+		return gatherSrv, fmt.Errorf("Provided incorrect domain <%s>", c.Val())
 	}
 	for c.NextBlock() {
-		suffix := parseDomain(c.Val())
-		if suffix == "" {
-			return plugin.Error(gatherSrvPluginName, fmt.Errorf("Provided incorrect domain <%s>", c.Val()))
+		switch c.Val() {
+		case "types":
+			types, err := parseTypes(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Types = types
+		case "timeout":
+			timeout, err := parseDuration(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Timeout = timeout
+		case "gather":
+			policy, err := parseGatherPolicy(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Policy = policy
+		case "hedge":
+			parsed, err := parseHedge(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			hedge = parsed
+		case "retry":
+			parsed, err := parseRetryPolicy(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			retry = parsed
+		case "breaker":
+			parsed, err := parseCircuitBreaker(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			breaker = parsed
+		case "health_check":
+			health, err := parseHealthChecker(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Health = health
+		case "min_healthy":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return gatherSrv, c.ArgErr()
+			}
+			parsed, err := strconv.Atoi(args[0])
+			if err != nil || parsed < 0 {
+				return gatherSrv, c.Errf("incorrect minimal number of healthy clusters <%s>", args[0])
+			}
+			minHealthy = parsed
+		case "serve_stale":
+			stale, err := parseStaleStore(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Stale = stale
+		case "cache":
+			responseCache, err := parseResponseCache(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Cache = responseCache
+		case "ttl":
+			policy, err := parseTTLPolicy(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.TTL = policy
+		case "txt":
+			policy, err := parseTXTPolicy(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.TXT = policy
+		case "flatten_cname":
+			hops, err := parseFlattenHops(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.FlattenHops = hops
+		case "keep_duplicates":
+			if c.NextArg() {
+				return gatherSrv, c.ArgErr()
+			}
+			gatherSrv.KeepDuplicates = true
+		case "coalesce":
+			if c.NextArg() {
+				return gatherSrv, c.ArgErr()
+			}
+			gatherSrv.Coalescer = NewCoalescer()
+		case "strict_bailiwick":
+			if c.NextArg() {
+				return gatherSrv, c.ArgErr()
+			}
+			gatherSrv.StrictBailiwick = true
+		case "rcode_precedence":
+			precedence, err := parseRcodePrecedence(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Precedence = precedence
+		case "sign":
+			signer, err := parseSigner(c, gatherSrv.Domain)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Signer = signer
+		case "soa":
+			authority, err := parseAuthority(c, gatherSrv.Domain)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Authority = authority
+		default:
+			cluster, err := parseCluster(c)
+			if err != nil {
+				return gatherSrv, err
+			}
+			gatherSrv.Clusters = append(gatherSrv.Clusters, cluster)
 		}
-		if !c.NextArg() {
-			return plugin.Error(gatherSrvPluginName, c.ArgErr())
+	}
+	if c.NextArg() {
+		return gatherSrv, c.ArgErr()
+	}
+
+	if len(gatherSrv.Clusters) == 0 {
+		return gatherSrv, fmt.Errorf("You have to provide at least one cluster definition.")
+	}
+	if gatherSrv.TXT != nil && gatherSrv.TXT.mode == txtModePrimary && !hasClusterPrefix(gatherSrv.Clusters, gatherSrv.TXT.primary) {
+		return gatherSrv, fmt.Errorf("Provided incorrect primary cluster prefix <%s> of txt policy.", gatherSrv.TXT.primary)
+	}
+	if gatherSrv.Signer != nil && gatherSrv.Authority == nil {
+		// denials of existence are signed only along with SOA record
+		authority, err := NewAuthority(gatherSrv.Domain, "", "", 0, defaultSOAMinimum)
+		if err != nil {
+			return gatherSrv, err
 		}
-		prefix := c.Val()
-		if c.NextArg() {
-			return plugin.Error(gatherSrvPluginName, c.ArgErr())
+		gatherSrv.Authority = authority
+	}
+	if minHealthy >= 0 {
+		if gatherSrv.Health == nil {
+			return gatherSrv, fmt.Errorf("Option min_healthy requires health_check to be defined.")
 		}
-		clusters = append(clusters, Cluster{Prefix: prefix, Suffix: suffix})
+		gatherSrv.Health.minHealthy = minHealthy
 	}
-	if c.NextArg() {
-		return plugin.Error(gatherSrvPluginName, c.ArgErr())
+	for i := range gatherSrv.Clusters {
+		if gatherSrv.Clusters[i].Hedge == nil && hedge != nil {
+			// each cluster tracks its own latencies
+			gatherSrv.Clusters[i].Hedge = hedge.Clone()
+		}
+		if gatherSrv.Clusters[i].Retry == nil {
+			gatherSrv.Clusters[i].Retry = retry
+		}
+		if gatherSrv.Clusters[i].Breaker == nil && breaker != nil {
+			// each cluster tracks its own failures
+			gatherSrv.Clusters[i].Breaker = breaker.Clone()
+		}
 	}
+	return gatherSrv, nil
+}
 
-	if len(clusters) == 0 {
-		return plugin.Error(gatherSrvPluginName, fmt.Errorf("You have to provide at least one cluster definition."))
+// parseCluster parses cluster definition: CLUSTER_DOMAIN HOSTNAME_PREFIX [UPSTREAM...] [{ ... }]
+func parseCluster(c *caddy.Controller) (Cluster, error) {
+	suffix := parseDomain(c.Val())
+	if suffix == "" {
+		return Cluster{}, fmt.Errorf("Provided incorrect domain <%s>", c.Val())
 	}
+	if !c.NextArg() || c.Val() == "{" {
+		return Cluster{}, c.ArgErr()
+	}
+	cluster := Cluster{Prefix: c.Val(), Suffix: suffix}
+	var addresses []string
+	for c.NextArg() {
+		if c.Val() == "{" {
+			if err := parseClusterBlock(c, &cluster); err != nil {
+				return cluster, err
+			}
+			break
+		}
+		addresses = append(addresses, c.Val())
+	}
+	if len(addresses) > 0 {
+		upstream, err := NewUpstream(addresses)
+		if err != nil {
+			return cluster, err
+		}
+		cluster.Upstream = upstream
+	}
+	return cluster, nil
+}
 
-	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-		return GatherSrv{Clusters: clusters, Domain: domain, Next: next}
-	})
+// parseClusterBlock parses properties of a single cluster defined between curly braces
+func parseClusterBlock(c *caddy.Controller, cluster *Cluster) error {
+	for c.Next() {
+		switch c.Val() {
+		case "}":
+			if c.NextArg() {
+				return c.ArgErr()
+			}
+			return nil
+		case "timeout":
+			timeout, err := parseDuration(c)
+			if err != nil {
+				return err
+			}
+			cluster.Timeout = timeout
+		case "hedge":
+			hedge, err := parseHedge(c)
+			if err != nil {
+				return err
+			}
+			cluster.Hedge = hedge
+		case "retry":
+			retry, err := parseRetryPolicy(c)
+			if err != nil {
+				return err
+			}
+			cluster.Retry = retry
+		case "breaker":
+			breaker, err := parseCircuitBreaker(c)
+			if err != nil {
+				return err
+			}
+			cluster.Breaker = breaker
+		case "reverse":
+			zones, err := parseReverseZones(c)
+			if err != nil {
+				return err
+			}
+			cluster.ReverseZones = append(cluster.ReverseZones, zones...)
+		case "ttl":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			ttl, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil || ttl == 0 {
+				return c.Errf("incorrect ttl <%s> of cluster <%s>", args[0], cluster.Suffix)
+			}
+			cluster.TTL = uint32(ttl)
+		default:
+			return c.Errf("unknown property '%s' of cluster <%s>", c.Val(), cluster.Suffix)
+		}
+	}
+	return c.EOFErr()
+}
 
-	return nil
+// parseReverseZones parses: reverse CIDR|ZONE...
+func parseReverseZones(c *caddy.Controller) ([]string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	var zones []string
+	for _, arg := range args {
+		normalized := plugin.Host(arg).NormalizeExact()
+		if len(normalized) == 0 {
+			return nil, c.Errf("incorrect reverse zone <%s>", arg)
+		}
+		for _, zone := range normalized {
+			if !dns.IsSubDomain("in-addr.arpa.", zone) && !dns.IsSubDomain("ip6.arpa.", zone) {
+				return nil, c.Errf("incorrect reverse zone <%s>", arg)
+			}
+		}
+		zones = append(zones, normalized...)
+	}
+	return zones, nil
+}
+
+// parseGatherPolicy parses: gather all|quorum [GRACE] or gather first N [GRACE]
+func parseGatherPolicy(c *caddy.Controller) (GatherPolicy, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return GatherPolicy{}, c.ArgErr()
+	}
+	mode, args := args[0], args[1:]
+	count := 0
+	if mode == "first" && len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil {
+			return GatherPolicy{}, c.Errf("incorrect number of clusters <%s> for gather mode <first>", args[0])
+		}
+		count, args = parsed, args[1:]
+	}
+	var grace time.Duration
+	if len(args) > 0 {
+		parsed, err := time.ParseDuration(args[0])
+		if err != nil || parsed < 0 {
+			return GatherPolicy{}, c.Errf("incorrect duration <%s> of gather grace window", args[0])
+		}
+		grace, args = parsed, args[1:]
+	}
+	if len(args) > 0 {
+		return GatherPolicy{}, c.ArgErr()
+	}
+	return NewGatherPolicy(mode, count, grace)
+}
+
+func parseHedge(c *caddy.Controller) (*Hedge, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return nil, c.ArgErr()
+	}
+	return NewHedge(args[0])
+}
+
+// parseRetryPolicy parses: retry ATTEMPTS [BACKOFF] [RCODE...]
+func parseRetryPolicy(c *caddy.Controller) (*RetryPolicy, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	attempts, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, c.Errf("incorrect number of retry attempts <%s>", args[0])
+	}
+	args = args[1:]
+	var backoff time.Duration
+	if len(args) > 0 {
+		if parsed, err := time.ParseDuration(args[0]); err == nil && parsed >= 0 {
+			backoff, args = parsed, args[1:]
+		}
+	}
+	return NewRetryPolicy(attempts, backoff, args)
+}
+
+// parseCircuitBreaker parses: breaker [FAILURES] [RATE] [COOLDOWN]
+func parseCircuitBreaker(c *caddy.Controller) (*CircuitBreaker, error) {
+	args := c.RemainingArgs()
+	failures, rate, cooldown := defaultBreakerFailures, 0.0, defaultBreakerCooldown
+	if len(args) > 0 {
+		if parsed, err := strconv.Atoi(args[0]); err == nil {
+			failures, args = parsed, args[1:]
+		}
+	}
+	if len(args) > 0 {
+		if parsed, err := strconv.ParseFloat(args[0], 64); err == nil {
+			rate, args = parsed, args[1:]
+		}
+	}
+	if len(args) > 0 {
+		parsed, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, c.Errf("incorrect breaker cooldown <%s>", args[0])
+		}
+		cooldown, args = parsed, args[1:]
+	}
+	if len(args) > 0 {
+		return nil, c.ArgErr()
+	}
+	return NewCircuitBreaker(failures, rate, cooldown)
+}
+
+// parseHealthChecker parses: health_check NAME [INTERVAL] [TIMEOUT]
+func parseHealthChecker(c *caddy.Controller) (*HealthChecker, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 || len(args) > 3 {
+		return nil, c.ArgErr()
+	}
+	durations := []time.Duration{defaultHealthCheckInterval, defaultHealthCheckTimeout}
+	for i, arg := range args[1:] {
+		parsed, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, c.Errf("incorrect duration <%s> of health_check", arg)
+		}
+		durations[i] = parsed
+	}
+	return NewHealthChecker(args[0], durations[0], durations[1], 1)
+}
+
+// parseStaleStore parses: serve_stale [MAX_STALENESS] [TTL]
+func parseStaleStore(c *caddy.Controller) (*StaleStore, error) {
+	args := c.RemainingArgs()
+	if len(args) > 2 {
+		return nil, c.ArgErr()
+	}
+	maxStaleness, ttl := defaultMaxStaleness, uint32(defaultStaleTTL)
+	if len(args) > 0 {
+		parsed, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, c.Errf("incorrect max staleness <%s>", args[0])
+		}
+		maxStaleness = parsed
+	}
+	if len(args) > 1 {
+		parsed, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return nil, c.Errf("incorrect stale ttl <%s>", args[1])
+		}
+		ttl = uint32(parsed)
+	}
+	return NewStaleStore(maxStaleness, ttl)
+}
+
+// parseResponseCache parses: cache [MAX_TTL] [NEGATIVE_TTL]
+func parseResponseCache(c *caddy.Controller) (*ResponseCache, error) {
+	args := c.RemainingArgs()
+	if len(args) > 2 {
+		return nil, c.ArgErr()
+	}
+	ttls := []uint32{defaultCacheMaxTTL, defaultCacheNegativeTTL}
+	for i, arg := range args {
+		parsed, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return nil, c.Errf("incorrect cache ttl <%s>", arg)
+		}
+		ttls[i] = uint32(parsed)
+	}
+	return NewResponseCache(ttls[0], ttls[1])
+}
+
+func parseDuration(c *caddy.Controller) (time.Duration, error) {
+	property := c.Val()
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return 0, c.ArgErr()
+	}
+	duration, err := time.ParseDuration(args[0])
+	if err != nil || duration <= 0 {
+		return 0, c.Errf("incorrect duration <%s> of %s", args[0], property)
+	}
+	return duration, nil
+}
+
+// parseTTLPolicy parses: ttl keep|min|clamp MIN MAX [partial TTL]
+func parseTTLPolicy(c *caddy.Controller) (*TTLPolicy, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	mode, args := args[0], args[1:]
+	values := []uint32{0, 0, 0}
+	parse := func(i int, arg string) error {
+		parsed, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return c.Errf("incorrect ttl <%s>", arg)
+		}
+		values[i] = uint32(parsed)
+		return nil
+	}
+	if mode == ttlModeClamp {
+		if len(args) < 2 {
+			return nil, c.ArgErr()
+		}
+		for i := 0; i < 2; i++ {
+			if err := parse(i, args[i]); err != nil {
+				return nil, err
+			}
+		}
+		args = args[2:]
+	}
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != "partial" {
+			return nil, c.ArgErr()
+		}
+		if err := parse(2, args[1]); err != nil {
+			return nil, err
+		}
+	}
+	return NewTTLPolicy(mode, values[0], values[1], values[2])
+}
+
+// parseTXTPolicy parses: txt all|first|consensus|concat or txt primary PREFIX
+func parseTXTPolicy(c *caddy.Controller) (*TXTPolicy, error) {
+	args := c.RemainingArgs()
+	switch {
+	case len(args) == 1:
+		return NewTXTPolicy(args[0], "")
+	case len(args) == 2 && args[0] == txtModePrimary:
+		return NewTXTPolicy(args[0], args[1])
+	default:
+		return nil, c.ArgErr()
+	}
+}
+
+func hasClusterPrefix(clusters []Cluster, prefix string) bool {
+	for _, cluster := range clusters {
+		if cluster.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlattenHops parses: flatten_cname [MAX_HOPS]
+func parseFlattenHops(c *caddy.Controller) (int, error) {
+	args := c.RemainingArgs()
+	if len(args) > 1 {
+		return 0, c.ArgErr()
+	}
+	if len(args) == 0 {
+		return defaultFlattenHops, nil
+	}
+	hops, err := strconv.Atoi(args[0])
+	if err != nil || hops < 1 {
+		return 0, c.Errf("incorrect number of CNAME hops <%s>", args[0])
+	}
+	return hops, nil
+}
+
+// parseTypes parses: types TYPE...
+func parseTypes(c *caddy.Controller) ([]uint16, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	types := make([]uint16, 0, len(args))
+	for _, arg := range args {
+		rrtype, ok := dns.StringToType[strings.ToUpper(arg)]
+		if !ok {
+			return nil, c.Errf("incorrect type <%s>", arg)
+		}
+		if !IsMasqueradedType(rrtype) {
+			return nil, c.Errf("records of type <%s> could not be masqueraded", arg)
+		}
+		types = append(types, rrtype)
+	}
+	return types, nil
+}
+
+// parseRcodePrecedence parses: rcode_precedence CLASS CLASS CLASS CLASS
+func parseRcodePrecedence(c *caddy.Controller) (*RcodePrecedence, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	return NewRcodePrecedence(args)
+}
+
+// parseAuthority parses: soa masquerade | soa [MNAME] [RNAME] [SERIAL] [MINIMUM]
+func parseAuthority(c *caddy.Controller, domain string) (*Authority, error) {
+	args := c.RemainingArgs()
+	if len(args) == 1 && args[0] == "masquerade" {
+		return NewMasqueradingAuthority(), nil
+	}
+	if len(args) > 4 {
+		return nil, c.ArgErr()
+	}
+	names := []string{"", ""}
+	for i := 0; i < len(args) && i < 2; i++ {
+		names[i] = parseDomain(args[i])
+		if names[i] == "" {
+			return nil, c.Errf("incorrect soa name <%s>", args[i])
+		}
+	}
+	values := []uint32{0, defaultSOAMinimum}
+	for i := 2; i < len(args); i++ {
+		parsed, err := strconv.ParseUint(args[i], 10, 32)
+		if err != nil {
+			return nil, c.Errf("incorrect soa value <%s>", args[i])
+		}
+		values[i-2] = uint32(parsed)
+	}
+	return NewAuthority(domain, names[0], names[1], values[0], values[1])
+}
+
+// parseSigner parses: sign KEY... [capacity CAPACITY], keys are given as paths without or with .key/.private extension
+func parseSigner(c *caddy.Controller, domain string) (*Signer, error) {
+	args := c.RemainingArgs()
+	capacity := defaultSignatureCapacity
+	if len(args) > 2 && args[len(args)-2] == "capacity" {
+		parsed, err := strconv.Atoi(args[len(args)-1])
+		if err != nil || parsed <= 0 {
+			return nil, c.Errf("incorrect signature cache capacity <%s>", args[len(args)-1])
+		}
+		capacity, args = parsed, args[:len(args)-2]
+	}
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	root := dnsserver.GetConfig(c).Root
+	keys := make([]*dnssec.DNSKEY, 0, len(args))
+	for _, arg := range args {
+		base := strings.TrimSuffix(strings.TrimSuffix(arg, ".key"), ".private")
+		if !filepath.IsAbs(base) && root != "" {
+			base = filepath.Join(root, base)
+		}
+		key, err := dnssec.ParseKeyFile(base+".key", base+".private")
+		if err != nil {
+			return nil, c.Errf("incorrect key <%s>: %s", arg, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewSigner(domain, keys, capacity)
 }
 
 func parseDomain(raw string) string {
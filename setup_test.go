@@ -1,8 +1,10 @@
 package gathersrv
 
 import (
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 )
@@ -39,3 +41,465 @@ func TestShouldSetupProperly(t *testing.T) {
 	err := setup(c)
 	require.NoError(t, err)
 }
+
+func TestShouldSetupClusterUpstreams(t *testing.T) {
+	config := `gathersrv distro.local. {
+	cluster-a.local. a- 10.8.0.1 tcp://10.8.0.2:5353
+	cluster-b.local. b-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Len(t, gatherSrv.Clusters, 2)
+	require.NotNil(t, gatherSrv.Clusters[0].Upstream)
+	require.Equal(
+		t,
+		[]upstreamAddress{{"udp", "10.8.0.1:53"}, {"tcp", "10.8.0.2:5353"}},
+		gatherSrv.Clusters[0].Upstream.addresses,
+	)
+	require.Nil(t, gatherSrv.Clusters[1].Upstream)
+}
+
+func TestShouldFailIfPassedIncorrectUpstream(t *testing.T) {
+	config := `gathersrv distro.local. {
+	cluster-a.local. a- dns.cluster-a.local
+}`
+	c := caddy.NewTestController("dns", config)
+	err := setup(c)
+	require.Errorf(t, err, "Expected error if upstream address is incorrect")
+	require.Contains(t, err.Error(), "Provided incorrect upstream address <dns.cluster-a.local>")
+}
+
+func TestShouldSetupTimeouts(t *testing.T) {
+	config := `gathersrv distro.local. {
+	timeout 2s
+	cluster-a.local. a- 10.8.0.1 {
+		timeout 500ms
+	}
+	cluster-b.local. b-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, gatherSrv.Timeout)
+	require.Len(t, gatherSrv.Clusters, 2)
+	require.Equal(t, 500*time.Millisecond, gatherSrv.Clusters[0].Timeout)
+	require.NotNil(t, gatherSrv.Clusters[0].Upstream)
+	require.Equal(t, time.Duration(0), gatherSrv.Clusters[1].Timeout)
+}
+
+func TestShouldFailIfPassedIncorrectTimeout(t *testing.T) {
+	configs := map[string]string{
+		`gathersrv distro.local. {
+	timeout soon
+	cluster-a.local. a-
+}`: "incorrect duration <soon> of timeout",
+		`gathersrv distro.local. {
+	cluster-a.local. a- {
+		timeout -1s
+	}
+}`: "incorrect duration <-1s> of timeout",
+		`gathersrv distro.local. {
+	cluster-a.local. a- {
+		deadline 1s
+	}
+}`: "unknown property 'deadline' of cluster <cluster-a.local.>",
+	}
+	for config, expectedError := range configs {
+		c := caddy.NewTestController("dns", config)
+		err := setup(c)
+		require.Errorf(t, err, "Expected error for config: %s", config)
+		require.Contains(t, err.Error(), expectedError)
+	}
+}
+
+func TestShouldSetupGatherPolicy(t *testing.T) {
+	expectations := map[string]GatherPolicy{
+		"gather all":           {mode: gatherAll},
+		"gather quorum":        {mode: gatherQuorum},
+		"gather quorum 20ms":   {mode: gatherQuorum, grace: 20 * time.Millisecond},
+		"gather first 2":       {mode: gatherFirst, count: 2},
+		"gather first 1 100ms": {mode: gatherFirst, count: 1, grace: 100 * time.Millisecond},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equalf(t, expected, gatherSrv.Policy, "Unexpected policy for: %s", directive)
+	}
+
+	for _, directive := range []string{"gather", "gather some", "gather first", "gather first two", "gather all later", "gather all 1s 2s"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupHedging(t *testing.T) {
+	config := `gathersrv distro.local. {
+	hedge p95
+	cluster-a.local. a- {
+		hedge 30ms
+	}
+	cluster-b.local. b-
+	cluster-c.local. c-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(t, &Hedge{delay: 30 * time.Millisecond}, gatherSrv.Clusters[0].Hedge)
+	require.Equal(t, 95, gatherSrv.Clusters[1].Hedge.percentile)
+	require.Equal(t, 95, gatherSrv.Clusters[2].Hedge.percentile)
+	require.NotSame(t, gatherSrv.Clusters[1].Hedge.latencies, gatherSrv.Clusters[2].Hedge.latencies)
+
+	for _, directive := range []string{"hedge", "hedge p100", "hedge never", "hedge 10ms p90"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupRetryPolicy(t *testing.T) {
+	config := `gathersrv distro.local. {
+	retry 2
+	cluster-a.local. a- {
+		retry 3 10ms SERVFAIL REFUSED
+	}
+	cluster-b.local. b-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		&RetryPolicy{attempts: 3, backoff: 10 * time.Millisecond, rcodes: []int{dns.RcodeServerFailure, dns.RcodeRefused}},
+		gatherSrv.Clusters[0].Retry,
+	)
+	require.Equal(t, &RetryPolicy{attempts: 2, rcodes: []int{dns.RcodeServerFailure}}, gatherSrv.Clusters[1].Retry)
+
+	for _, directive := range []string{"retry", "retry many", "retry 0", "retry 2 10ms BROKEN"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupCircuitBreaker(t *testing.T) {
+	config := `gathersrv distro.local. {
+	breaker
+	cluster-a.local. a- {
+		breaker 3 0.5 10s
+	}
+	cluster-b.local. b-
+	cluster-c.local. c-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(t, &CircuitBreaker{failures: 3, rate: 0.5, cooldown: 10 * time.Second}, gatherSrv.Clusters[0].Breaker)
+	require.Equal(
+		t,
+		&CircuitBreaker{failures: defaultBreakerFailures, cooldown: defaultBreakerCooldown},
+		gatherSrv.Clusters[1].Breaker,
+	)
+	require.NotSame(t, gatherSrv.Clusters[1].Breaker, gatherSrv.Clusters[2].Breaker)
+
+	for _, directive := range []string{"breaker 0", "breaker 3 1.5", "breaker 3 0.5 never", "breaker 3 0.5 1s 2s"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupHealthChecker(t *testing.T) {
+	config := `gathersrv distro.local. {
+	health_check kubernetes.default.svc 5s 1s
+	min_healthy 2
+	cluster-a.local. a-
+	cluster-b.local. b-
+}`
+	c := caddy.NewTestController("dns", config)
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(t, "kubernetes.default.svc", gatherSrv.Health.name)
+	require.Equal(t, 5*time.Second, gatherSrv.Health.interval)
+	require.Equal(t, time.Second, gatherSrv.Health.timeout)
+	require.Equal(t, 2, gatherSrv.Health.minHealthy)
+
+	for _, directive := range []string{"health_check", "health_check kubernetes 1m never", "min_healthy 1", "health_check k8s\nmin_healthy -1"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupServeStale(t *testing.T) {
+	expectations := map[string]*StaleStore{
+		"serve_stale":        {maxStaleness: defaultMaxStaleness, ttl: defaultStaleTTL},
+		"serve_stale 10m":    {maxStaleness: 10 * time.Minute, ttl: defaultStaleTTL},
+		"serve_stale 10m 15": {maxStaleness: 10 * time.Minute, ttl: 15},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equal(t, expected.maxStaleness, gatherSrv.Stale.maxStaleness)
+		require.Equal(t, expected.ttl, gatherSrv.Stale.ttl)
+	}
+
+	for _, directive := range []string{"serve_stale never", "serve_stale 0s", "serve_stale 1m -1", "serve_stale 1m 1 1"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupResponseCache(t *testing.T) {
+	expectations := map[string]*ResponseCache{
+		"cache":        {maxTTL: defaultCacheMaxTTL, negativeTTL: defaultCacheNegativeTTL},
+		"cache 600":    {maxTTL: 600, negativeTTL: defaultCacheNegativeTTL},
+		"cache 600 10": {maxTTL: 600, negativeTTL: 10},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equal(t, expected.maxTTL, gatherSrv.Cache.maxTTL)
+		require.Equal(t, expected.negativeTTL, gatherSrv.Cache.negativeTTL)
+	}
+
+	for _, directive := range []string{"cache forever", "cache 0", "cache 600 -1", "cache 600 10 1"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupAuthority(t *testing.T) {
+	expectations := map[string]*dns.SOA{
+		"soa": {Ns: "ns.dns.distro.local.", Mbox: "hostmaster.distro.local.", Minttl: defaultSOAMinimum},
+		"soa ns1.distro.local. admin.example.com.":        {Ns: "ns1.distro.local.", Mbox: "admin.example.com.", Minttl: defaultSOAMinimum},
+		"soa ns1.distro.local. admin.example.com. 42":     {Ns: "ns1.distro.local.", Mbox: "admin.example.com.", Serial: 42, Minttl: defaultSOAMinimum},
+		"soa ns1.distro.local. admin.example.com. 42 120": {Ns: "ns1.distro.local.", Mbox: "admin.example.com.", Serial: 42, Minttl: 120},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.False(t, gatherSrv.Authority.masquerade)
+		require.Equal(t, "distro.local.", gatherSrv.Authority.soa.Hdr.Name)
+		require.Equal(t, expected.Ns, gatherSrv.Authority.soa.Ns)
+		require.Equal(t, expected.Mbox, gatherSrv.Authority.soa.Mbox)
+		require.Equal(t, expected.Minttl, gatherSrv.Authority.soa.Minttl)
+		require.Equal(t, expected.Minttl, gatherSrv.Authority.soa.Hdr.Ttl)
+		if expected.Serial != 0 {
+			require.Equal(t, expected.Serial, gatherSrv.Authority.soa.Serial)
+		} else {
+			require.NotZero(t, gatherSrv.Authority.soa.Serial)
+		}
+	}
+
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\nsoa masquerade\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.True(t, gatherSrv.Authority.masquerade)
+
+	for _, directive := range []string{
+		"soa ns1", "soa ns1.distro.local. admin", "soa ns1.distro.local. admin.example.com. first",
+		"soa ns1.distro.local. admin.example.com. 1 -1", "soa ns1.distro.local. admin.example.com. 1 1 1",
+	} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupRcodePrecedence(t *testing.T) {
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\nrcode_precedence NOERROR NXDOMAIN NODATA SERVFAIL\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[outcome]int{outcomeData: 0, outcomeNameError: 1, outcomeNoData: 2, outcomeFailure: 3},
+		gatherSrv.Precedence.ranks,
+	)
+
+	for _, directive := range []string{"rcode_precedence", "rcode_precedence NOERROR NODATA", "rcode_precedence NOERROR NODATA NXDOMAIN REFUSED"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupTTLPolicy(t *testing.T) {
+	expectations := map[string]*TTLPolicy{
+		"ttl keep":                  {mode: ttlModeKeep},
+		"ttl min":                   {mode: ttlModeMin},
+		"ttl min partial 5":         {mode: ttlModeMin, partial: 5},
+		"ttl clamp 10 60":           {mode: ttlModeClamp, min: 10, max: 60},
+		"ttl clamp 10 60 partial 5": {mode: ttlModeClamp, min: 10, max: 60, partial: 5},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equalf(t, expected, gatherSrv.TTL, "Unexpected policy for: %s", directive)
+	}
+
+	config := `gathersrv distro.local. {
+	cluster-a.local. a- {
+		ttl 15
+	}
+	cluster-b.local. b-
+}`
+	gatherSrv, err := parse(caddy.NewTestController("dns", config))
+	require.NoError(t, err)
+	require.Equal(t, uint32(15), gatherSrv.Clusters[0].TTL)
+	require.Equal(t, uint32(0), gatherSrv.Clusters[1].TTL)
+
+	for _, directive := range []string{
+		"ttl", "ttl max", "ttl clamp 10", "ttl clamp 60 10", "ttl clamp ten 60", "ttl min partial",
+		"ttl min partial -1", "ttl keep 5", "cluster-b.local. b- {\nttl 0\n}", "cluster-b.local. b- {\nttl\n}",
+	} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupKeepingDuplicates(t *testing.T) {
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\nkeep_duplicates\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.True(t, gatherSrv.KeepDuplicates)
+
+	c = caddy.NewTestController("dns", "gathersrv distro.local. {\ncluster-a.local. a-\n}")
+	gatherSrv, err = parse(c)
+	require.NoError(t, err)
+	require.False(t, gatherSrv.KeepDuplicates)
+
+	c = caddy.NewTestController("dns", "gathersrv distro.local. {\nkeep_duplicates yes\ncluster-a.local. a-\n}")
+	_, err = parse(c)
+	require.Error(t, err)
+}
+
+func TestShouldSetupTXTPolicy(t *testing.T) {
+	expectations := map[string]*TXTPolicy{
+		"txt all":        {mode: txtModeAll},
+		"txt first":      {mode: txtModeFirst},
+		"txt consensus":  {mode: txtModeConsensus},
+		"txt concat":     {mode: txtModeConcat},
+		"txt primary a-": {mode: txtModePrimary, primary: "a-"},
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equalf(t, expected, gatherSrv.TXT, "Unexpected policy for: %s", directive)
+	}
+
+	for _, directive := range []string{"txt", "txt any", "txt primary", "txt primary b-", "txt first a-", "txt primary a- b-"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupCNAMEFlattening(t *testing.T) {
+	expectations := map[string]int{
+		"flatten_cname":   defaultFlattenHops,
+		"flatten_cname 3": 3,
+	}
+	for directive, expected := range expectations {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		gatherSrv, err := parse(c)
+		require.NoError(t, err)
+		require.Equal(t, expected, gatherSrv.FlattenHops)
+	}
+
+	for _, directive := range []string{"flatten_cname 0", "flatten_cname many", "flatten_cname 1 2"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupReverseZones(t *testing.T) {
+	config := `gathersrv distro.local. {
+	cluster-a.local. a- {
+		reverse 10.8.0.0/16 9.10.in-addr.arpa.
+		reverse fd00::/8
+	}
+	cluster-b.local. b-
+}`
+	gatherSrv, err := parse(caddy.NewTestController("dns", config))
+	require.NoError(t, err)
+	require.Equal(t, []string{"8.10.in-addr.arpa.", "9.10.in-addr.arpa.", "d.f.ip6.arpa."}, gatherSrv.Clusters[0].ReverseZones)
+	require.Empty(t, gatherSrv.Clusters[1].ReverseZones)
+
+	gatherSrv, err = parse(caddy.NewTestController("dns", "gathersrv distro.local. {\ntypes A PTR\ncluster-a.local. a- {\nreverse 10.8.0.0/16\n}\n}"))
+	require.NoError(t, err)
+	require.Equal(t, []uint16{dns.TypeA, dns.TypePTR}, gatherSrv.Types)
+
+	for _, reverse := range []string{"reverse", "reverse cluster-a.local.", "reverse 10.8.0.0/33"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\ncluster-a.local. a- {\n"+reverse+"\n}\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", reverse)
+	}
+}
+
+func TestShouldSetupProxiedTypes(t *testing.T) {
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\ntypes SRV a https\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{dns.TypeSRV, dns.TypeA, dns.TypeHTTPS}, gatherSrv.Types)
+
+	for _, directive := range []string{"types", "types SRV UNKNOWN", "types SRV DNSKEY"} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupSigning(t *testing.T) {
+	base := PrepareKeyFiles(t, "distro.local.")
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\nsign "+base+".private capacity 100\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.NotNil(t, gatherSrv.Signer)
+	require.Len(t, gatherSrv.Signer.keys, 1)
+	require.NotNil(t, gatherSrv.Authority, "Expected SOA synthesized for signed denials")
+
+	for _, directive := range []string{"sign", "sign " + base + " capacity 0", "sign " + base + ".missing", "sign " + PrepareKeyFiles(t, "other.local.")} {
+		c := caddy.NewTestController("dns", "gathersrv distro.local. {\n"+directive+"\ncluster-a.local. a-\n}")
+		_, err := parse(c)
+		require.Errorf(t, err, "Expected error for: %s", directive)
+	}
+}
+
+func TestShouldSetupStrictBailiwick(t *testing.T) {
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\nstrict_bailiwick\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.True(t, gatherSrv.StrictBailiwick)
+
+	c = caddy.NewTestController("dns", "gathersrv distro.local. {\nstrict_bailiwick yes\ncluster-a.local. a-\n}")
+	_, err = parse(c)
+	require.Error(t, err)
+}
+
+func TestShouldSetupCoalescing(t *testing.T) {
+	c := caddy.NewTestController("dns", "gathersrv distro.local. {\ncluster-a.local. a-\n}")
+	gatherSrv, err := parse(c)
+	require.NoError(t, err)
+	require.Nil(t, gatherSrv.Coalescer, "Expected coalescing to be disabled by default")
+
+	c = caddy.NewTestController("dns", "gathersrv distro.local. {\ncoalesce\ncluster-a.local. a-\n}")
+	gatherSrv, err = parse(c)
+	require.NoError(t, err)
+	require.NotNil(t, gatherSrv.Coalescer)
+
+	c = caddy.NewTestController("dns", "gathersrv distro.local. {\ncoalesce yes\ncluster-a.local. a-\n}")
+	_, err = parse(c)
+	require.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package gathersrv
+
+import (
+	"context"
+	"fmt"
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSignatureCapacity = 10000
+	dnskeyTTL                = 3600
+)
+
+// Signer signs merged responses for the distributed domain on-the-fly, so validating resolvers are able to trust them.
+// Denials of existence are synthesized as NSEC "black lies", which turn NXDOMAIN responses into NODATA ones.
+// Signatures of RRsets are cached, so repeated merges of unchanged records are not signed again.
+type Signer struct {
+	domain string
+	keys   []*dnssec.DNSKEY
+	dnssec dnssec.Dnssec
+}
+
+// NewSigner returns Signer using the keys of the distributed domain, capacity bounds the number of cached signatures.
+func NewSigner(domain string, keys []*dnssec.DNSKEY, capacity int) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required to sign <%s>", domain)
+	}
+	for _, key := range keys {
+		if !strings.EqualFold(key.K.Hdr.Name, domain) {
+			return nil, fmt.Errorf("incorrect key <%s> for domain <%s>", key.K.Hdr.Name, domain)
+		}
+	}
+	return &Signer{
+		domain: domain,
+		keys:   keys,
+		dnssec: dnssec.New([]string{domain}, keys, false, nil, cache.New(capacity)),
+	}, nil
+}
+
+// Sign adds signatures to the merged response if the client has asked for them and the question belongs to the distributed domain.
+// It reports whether the response has been signed.
+func (s *Signer) Sign(ctx context.Context, r *dns.Msg, response *dns.Msg) bool {
+	if opt := r.IsEdns0(); opt == nil || !opt.Do() || !dns.IsSubDomain(s.domain, r.Question[0].Name) {
+		return false
+	}
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return false
+	}
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		normalizeRRsetTTL(section)
+	}
+	s.dnssec.Sign(request.Request{Req: response, Zone: s.domain}, time.Now().UTC(), metrics.WithServer(ctx))
+	setDO(r, response)
+	return true
+}
+
+// IsKeyQuestion reports whether the question asks for DNSKEY records of the distributed domain
+func (s *Signer) IsKeyQuestion(question dns.Question) bool {
+	return question.Qtype == dns.TypeDNSKEY && strings.EqualFold(question.Name, s.domain)
+}
+
+// Keys returns the response with DNSKEY records of the distributed domain, signed if the client has asked for it
+func (s *Signer) Keys(ctx context.Context, r *dns.Msg) *dns.Msg {
+	response := new(dns.Msg)
+	response.SetReply(r)
+	response.Authoritative = true
+	for _, key := range s.keys {
+		record := dns.Copy(key.K)
+		record.Header().Name = s.domain
+		record.Header().Ttl = dnskeyTTL
+		response.Answer = append(response.Answer, record)
+	}
+	if opt := r.IsEdns0(); opt != nil && opt.Do() {
+		s.dnssec.Sign(request.Request{Req: response, Zone: s.domain}, time.Now().UTC(), metrics.WithServer(ctx))
+		setDO(r, response)
+	}
+	return response
+}
+
+// normalizeRRsetTTL sets TTL of all records of each RRset to the lowest one (RFC 2181), records of clusters may differ
+func normalizeRRsetTTL(records []dns.RR) {
+	lowest := map[string]uint32{}
+	key := func(rr dns.RR) string {
+		return fmt.Sprintf("%s/%d", strings.ToLower(rr.Header().Name), rr.Header().Rrtype)
+	}
+	for _, rr := range records {
+		if ttl, ok := lowest[key(rr)]; !ok || rr.Header().Ttl < ttl {
+			lowest[key(rr)] = rr.Header().Ttl
+		}
+	}
+	for _, rr := range records {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			rr.Header().Ttl = lowest[key(rr)]
+		}
+	}
+}
+
+// setDO marks the signed response with DO bit (RFC 3225), sub-responses come back without it
+func setDO(r *dns.Msg, response *dns.Msg) {
+	if opt := response.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return
+	}
+	response.SetEdns0(r.IsEdns0().UDPSize(), true)
+}
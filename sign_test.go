@@ -0,0 +1,152 @@
+package gathersrv
+
+import (
+	"context"
+	"crypto"
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// PrepareKeyFiles generates a key pair of the domain and stores it in the format of dnssec-keygen, the base path is returned
+func PrepareKeyFiles(t *testing.T, domain string) string {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: domain, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	require.NoError(t, err)
+	base := filepath.Join(t.TempDir(), "K"+domain+"+013+test")
+	require.NoError(t, os.WriteFile(base+".key", []byte(key.String()+"\n"), 0600))
+	require.NoError(t, os.WriteFile(base+".private", []byte(key.PrivateKeyString(private.(crypto.PrivateKey))), 0600))
+	return base
+}
+
+func PrepareSigner(t *testing.T, domain string) (*Signer, *dns.DNSKEY) {
+	base := PrepareKeyFiles(t, domain)
+	key, err := dnssec.ParseKeyFile(base+".key", base+".private")
+	require.NoError(t, err)
+	signer, err := NewSigner(domain, []*dnssec.DNSKEY{key}, defaultSignatureCapacity)
+	require.NoError(t, err)
+	return signer, key.K
+}
+
+func ServeSigned(t *testing.T, gatherPlugin *GatherSrv, name string, qtype uint16, do bool) *dns.Msg {
+	req := new(dns.Msg).SetQuestion(name, qtype)
+	req.SetEdns0(4096, do)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := gatherPlugin.ServeDNS(context.TODO(), rec, req)
+	require.NoError(t, err)
+	return rec.Msg
+}
+
+func VerifySignatures(t *testing.T, key *dns.DNSKEY, records []dns.RR) {
+	var signatures []*dns.RRSIG
+	for _, rr := range records {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			signatures = append(signatures, sig)
+		}
+	}
+	require.NotEmpty(t, signatures, "Expected signatures in: %v", records)
+	for _, sig := range signatures {
+		covered := []dns.RR{}
+		for _, rr := range records {
+			if rr.Header().Rrtype == sig.TypeCovered && rr.Header().Name == sig.Hdr.Name {
+				covered = append(covered, rr)
+			}
+		}
+		require.NoError(t, sig.Verify(key, covered), "Expected valid signature of %s", sig.Hdr.Name)
+	}
+}
+
+func TestShouldSignMergedResponse(t *testing.T) {
+	signer, key := PrepareSigner(t, "distro.local.")
+	assertion := Assertion{GivenType: dns.TypeA, ExpectedRcode: dns.RcodeSuccess}
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{"demo.svc.cluster-a.local.": assertion, "demo.svc.cluster-b.local.": assertion},
+			map[string][]dns.RR{
+				"demo.svc.cluster-a.local.": {test.A("demo.svc.cluster-a.local. 30 IN A 10.8.1.2")},
+				"demo.svc.cluster-b.local.": {test.A("demo.svc.cluster-b.local. 20 IN A 10.9.1.2")},
+			},
+			map[string][]dns.RR{},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Signer: signer,
+	}
+
+	msg := ServeSigned(t, gatherPlugin, "demo.svc.distro.local.", dns.TypeA, true)
+	require.Equal(t, dns.RcodeSuccess, msg.Rcode)
+	require.True(t, msg.IsEdns0().Do())
+	require.Len(t, msg.Answer, 4)
+	VerifySignatures(t, key, msg.Answer)
+
+	msg = ServeSigned(t, gatherPlugin, "demo.svc.distro.local.", dns.TypeA, false)
+	for _, rr := range msg.Answer {
+		require.NotEqual(t, dns.TypeRRSIG, rr.Header().Rrtype, "Expected no signatures without DO bit")
+	}
+}
+
+func TestShouldSynthesizeSignedDenial(t *testing.T) {
+	signer, key := PrepareSigner(t, "distro.local.")
+	authority, _ := NewAuthority("distro.local.", "", "", 42, 15)
+	gatherPlugin := &GatherSrv{
+		Next:   PrepareNegativeNextHandler(dns.RcodeNameError, map[string][]dns.RR{}),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Authority: authority,
+		Signer:    signer,
+	}
+
+	msg := ServeSigned(t, gatherPlugin, "missing.svc.distro.local.", dns.TypeA, true)
+	require.Equal(t, dns.RcodeSuccess, msg.Rcode, "Expected NXDOMAIN turned into NODATA")
+	require.Empty(t, msg.Answer)
+	var nsec *dns.NSEC
+	for _, rr := range msg.Ns {
+		if record, ok := rr.(*dns.NSEC); ok {
+			nsec = record
+		}
+	}
+	require.NotNil(t, nsec)
+	require.Equal(t, "missing.svc.distro.local.", nsec.Hdr.Name)
+	require.NotContains(t, nsec.TypeBitMap, dns.TypeA)
+	VerifySignatures(t, key, msg.Ns)
+}
+
+func TestShouldAnswerKeysOfDistributedDomain(t *testing.T) {
+	signer, key := PrepareSigner(t, "distro.local.")
+	gatherPlugin := &GatherSrv{
+		Next:     test.NextHandler(dns.RcodeRefused, nil),
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+		Signer:   signer,
+	}
+
+	msg := ServeSigned(t, gatherPlugin, "distro.local.", dns.TypeDNSKEY, true)
+	require.Equal(t, dns.RcodeSuccess, msg.Rcode)
+	require.True(t, msg.Authoritative)
+	require.Equal(t, key.PublicKey, msg.Answer[0].(*dns.DNSKEY).PublicKey)
+	VerifySignatures(t, key, msg.Answer)
+}
+
+func TestShouldRejectKeysOfOtherDomain(t *testing.T) {
+	base := PrepareKeyFiles(t, "other.local.")
+	key, err := dnssec.ParseKeyFile(base+".key", base+".private")
+	require.NoError(t, err)
+	_, err = NewSigner("distro.local.", []*dnssec.DNSKEY{key}, defaultSignatureCapacity)
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"time"
+)
+
+const (
+	defaultSOARefresh = 7200
+	defaultSOARetry   = 1800
+	defaultSOAExpire  = 86400
+	defaultSOAMinimum = 5
+)
+
+// Authority provides the SOA record placed in the authority section of negative merged responses (RFC 2308),
+// so downstream resolvers are able to cache NXDOMAIN and NODATA answers for the distributed domain.
+// The record is either synthesized for the distributed domain or masqueraded from SOA records returned by clusters.
+type Authority struct {
+	masquerade bool
+	soa        *dns.SOA
+}
+
+// NewAuthority returns Authority synthesizing SOA record of the distributed domain.
+// Empty mname and rname default to ns.dns.<domain> and hostmaster.<domain>, zero serial defaults to the current unix time.
+func NewAuthority(domain, mname, rname string, serial, minimum uint32) (*Authority, error) {
+	if mname == "" {
+		mname = "ns.dns." + domain
+	}
+	if rname == "" {
+		rname = "hostmaster." + domain
+	}
+	for _, name := range []string{mname, rname} {
+		if _, ok := dns.IsDomainName(name); !ok || !dns.IsFqdn(name) {
+			return nil, fmt.Errorf("incorrect soa name <%s>", name)
+		}
+	}
+	if serial == 0 {
+		serial = uint32(time.Now().Unix())
+	}
+	return &Authority{
+		soa: &dns.SOA{
+			Hdr:     dns.RR_Header{Name: domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: minimum},
+			Ns:      mname,
+			Mbox:    rname,
+			Serial:  serial,
+			Refresh: defaultSOARefresh,
+			Retry:   defaultSOARetry,
+			Expire:  defaultSOAExpire,
+			Minttl:  minimum,
+		},
+	}, nil
+}
+
+// NewMasqueradingAuthority returns Authority which uses SOA records of clusters translated into the distributed domain.
+func NewMasqueradingAuthority() *Authority {
+	return &Authority{masquerade: true}
+}
+
+// SOA returns the record for negative response, contributed are masqueraded SOA records gathered from clusters.
+// If clusters disagree, the lowest TTL and minimum are used. Nil is returned if there is nothing to masquerade.
+func (a *Authority) SOA(contributed []*dns.SOA) dns.RR {
+	if !a.masquerade {
+		return dns.Copy(a.soa)
+	}
+	var soa *dns.SOA
+	for _, record := range contributed {
+		if soa == nil {
+			soa = dns.Copy(record).(*dns.SOA)
+			continue
+		}
+		soa.Hdr.Ttl = min(soa.Hdr.Ttl, record.Hdr.Ttl)
+		soa.Minttl = min(soa.Minttl, record.Minttl)
+	}
+	if soa == nil {
+		return nil
+	}
+	return soa
+}
+
+// isNegative reports whether the response is NXDOMAIN or NODATA
+func isNegative(response *dns.Msg) bool {
+	return response.Rcode == dns.RcodeNameError || (response.Rcode == dns.RcodeSuccess && len(response.Answer) == 0)
+}
@@ -0,0 +1,115 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func PrepareNegativeNextHandler(rcode int, authorities map[string][]dns.RR) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		m.Ns = authorities[r.Question[0].Name]
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return rcode, nil
+	})
+}
+
+func TestShouldSynthesizeSOAForNegativeResponses(t *testing.T) {
+	authority, err := NewAuthority("distro.local.", "", "", 42, 15)
+	require.NoError(t, err)
+	for _, rcode := range []int{dns.RcodeNameError, dns.RcodeSuccess} {
+		gatherPlugin := &GatherSrv{
+			Next:   PrepareNegativeNextHandler(rcode, map[string][]dns.RR{}),
+			Domain: "distro.local.",
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-"},
+			},
+			Authority: authority,
+		}
+		msg := CheckAssertion(t, gatherPlugin, Assertion{
+			GivenName:     "missing.svc.distro.local.",
+			GivenType:     dns.TypeA,
+			ExpectedRcode: uint16(rcode),
+		})
+		require.Equal(
+			t,
+			[]string{"distro.local.\t15\tIN\tSOA\tns.dns.distro.local. hostmaster.distro.local. 42 7200 1800 86400 15"},
+			RecordsAsStrings(msg.Ns),
+		)
+	}
+}
+
+func TestShouldNotAddSOAToPositiveResponses(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeSuccess,
+	}
+	authority, _ := NewAuthority("distro.local.", "", "", 42, 15)
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{"demo.svc.cluster-a.local.": assertion},
+			map[string][]dns.RR{"demo.svc.cluster-a.local.": {test.A("demo.svc.cluster-a.local. 30 IN A 10.8.1.2")}},
+			map[string][]dns.RR{},
+		),
+		Domain:    "distro.local.",
+		Clusters:  []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+		Authority: authority,
+	}
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 1)
+	require.Empty(t, msg.Ns)
+}
+
+func TestShouldMasqueradeSOAOfClusters(t *testing.T) {
+	gatherPlugin := &GatherSrv{
+		Next: PrepareNegativeNextHandler(dns.RcodeNameError, map[string][]dns.RR{
+			"missing.svc.cluster-a.local.": {
+				test.SOA("cluster-a.local. 30 IN SOA ns.dns.cluster-a.local. hostmaster.cluster-a.local. 1 7200 1800 86400 10"),
+			},
+			"missing.svc.cluster-b.local.": {
+				test.SOA("cluster-b.local. 20 IN SOA ns.dns.cluster-b.local. hostmaster.cluster-b.local. 1 7200 1800 86400 30"),
+			},
+		}),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Authority: NewMasqueradingAuthority(),
+	}
+	msg := CheckAssertion(t, gatherPlugin, Assertion{
+		GivenName:     "missing.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeNameError,
+	})
+	require.Len(t, msg.Ns, 1)
+	soa := msg.Ns[0].(*dns.SOA)
+	require.Equal(t, "distro.local.", soa.Hdr.Name)
+	require.Equal(t, "ns.dns.distro.local.", soa.Ns)
+	require.Equal(t, "hostmaster.distro.local.", soa.Mbox)
+	require.Equal(t, uint32(20), soa.Hdr.Ttl)
+	require.Equal(t, uint32(10), soa.Minttl)
+}
+
+func TestShouldOmitSOAIfClustersHaveNotReturnedIt(t *testing.T) {
+	gatherPlugin := &GatherSrv{
+		Next:      PrepareNegativeNextHandler(dns.RcodeNameError, map[string][]dns.RR{}),
+		Domain:    "distro.local.",
+		Clusters:  []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+		Authority: NewMasqueradingAuthority(),
+	}
+	msg := CheckAssertion(t, gatherPlugin, Assertion{
+		GivenName:     "missing.svc.distro.local.",
+		GivenType:     dns.TypeA,
+		ExpectedRcode: dns.RcodeNameError,
+	})
+	require.Empty(t, msg.Ns)
+}
@@ -0,0 +1,83 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxStaleness  = time.Hour
+	defaultStaleTTL      = 30
+	defaultStaleCapacity = 10000
+)
+
+// StaleStore remembers the last good masqueraded records contributed by each cluster for a question,
+// so they could be served in place of the cluster response when the cluster fails or times out.
+type StaleStore struct {
+	maxStaleness time.Duration
+	ttl          uint32
+	entries      *cache.Cache
+}
+
+type staleEntry struct {
+	answer []dns.RR
+	extra  []dns.RR
+	stored time.Time
+}
+
+// NewStaleStore returns StaleStore serving records not older than maxStaleness with TTL reduced to ttl.
+func NewStaleStore(maxStaleness time.Duration, ttl uint32) (*StaleStore, error) {
+	if maxStaleness <= 0 {
+		return nil, fmt.Errorf("incorrect max staleness <%s>", maxStaleness)
+	}
+	return &StaleStore{maxStaleness: maxStaleness, ttl: ttl, entries: cache.New(defaultStaleCapacity)}, nil
+}
+
+// Remember stores records of the masqueraded sub-response of the cluster with given prefix.
+func (ss *StaleStore) Remember(question dns.Question, prefix string, res *dns.Msg) {
+	if res.Rcode != dns.RcodeSuccess || len(res.Answer) == 0 {
+		return
+	}
+	ss.entries.Add(contributionKey(question, prefix), &staleEntry{
+		answer: copyRecords(res.Answer),
+		extra:  copyRecords(res.Extra),
+		stored: time.Now(),
+	})
+}
+
+// Recall returns copies of records remembered for the cluster with given prefix with TTL reduced.
+func (ss *StaleStore) Recall(question dns.Question, prefix string) ([]dns.RR, []dns.RR, bool) {
+	value, ok := ss.entries.Get(contributionKey(question, prefix))
+	if !ok {
+		return nil, nil, false
+	}
+	entry := value.(*staleEntry)
+	if time.Since(entry.stored) > ss.maxStaleness {
+		return nil, nil, false
+	}
+	return ss.reduceTTL(copyRecords(entry.answer)), ss.reduceTTL(copyRecords(entry.extra)), true
+}
+
+func (ss *StaleStore) reduceTTL(records []dns.RR) []dns.RR {
+	for _, rr := range records {
+		if rr.Header().Ttl > ss.ttl {
+			rr.Header().Ttl = ss.ttl
+		}
+	}
+	return records
+}
+
+func contributionKey(question dns.Question, prefix string) uint64 {
+	return cache.Hash([]byte(fmt.Sprintf("%s/%d/%d/%s", strings.ToLower(question.Name), question.Qtype, question.Qclass, prefix)))
+}
+
+func copyRecords(records []dns.RR) []dns.RR {
+	copied := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		copied = append(copied, dns.Copy(rr))
+	}
+	return copied
+}
@@ -0,0 +1,113 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRecallRememberedRecordsWithReducedTTL(t *testing.T) {
+	stale, err := NewStaleStore(time.Hour, 5)
+	require.NoError(t, err)
+	question := dns.Question{Name: "_http._tcp.demo.svc.distro.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+	res := &dns.Msg{
+		Answer: []dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")},
+		Extra:  []dns.RR{test.A("a-demo-0.svc.distro.local. 3 IN A 10.8.1.2")},
+	}
+
+	_, _, ok := stale.Recall(question, "a-")
+	require.False(t, ok)
+
+	stale.Remember(question, "a-", res)
+	res.Answer[0].Header().Ttl = 1000
+
+	answer, extra, ok := stale.Recall(question, "a-")
+	require.True(t, ok)
+	require.Equal(t, []dns.RR{test.SRV("_http._tcp.demo.svc.distro.local. 5 IN SRV 0 50 8080 a-demo-0.svc.distro.local.")}, answer)
+	require.Equal(t, []dns.RR{test.A("a-demo-0.svc.distro.local. 3 IN A 10.8.1.2")}, extra)
+
+	_, _, ok = stale.Recall(question, "b-")
+	require.False(t, ok)
+}
+
+func TestShouldNotRecallRecordsOlderThanMaxStaleness(t *testing.T) {
+	stale, err := NewStaleStore(10*time.Millisecond, 5)
+	require.NoError(t, err)
+	question := dns.Question{Name: "demo.svc.distro.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	stale.Remember(question, "a-", &dns.Msg{Answer: []dns.RR{test.A("a-demo.svc.distro.local. 30 IN A 10.8.1.2")}})
+	stale.Remember(question, "b-", &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError}})
+
+	_, _, ok := stale.Recall(question, "b-")
+	require.False(t, ok, "Expected negative responses not to be remembered")
+
+	time.Sleep(20 * time.Millisecond)
+	_, _, ok = stale.Recall(question, "a-")
+	require.False(t, ok)
+}
+
+func TestShouldServeStaleRecordsOfFailedCluster(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	content := PrepareContentNextHandler(
+		map[string]Assertion{
+			"_http._tcp.demo.svc.cluster-a.local.": assertion,
+			"_http._tcp.demo.svc.cluster-b.local.": assertion,
+		},
+		map[string][]dns.RR{
+			"_http._tcp.demo.svc.cluster-a.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+			},
+			"_http._tcp.demo.svc.cluster-b.local.": {
+				test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+			},
+		},
+		map[string][]dns.RR{
+			"_http._tcp.demo.svc.cluster-b.local.": {test.A("demo-0.svc.cluster-b.local. 30 IN A 10.9.1.2")},
+		},
+	)
+	var failing atomic.Bool
+	next := test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		if failing.Load() && r.Question[0].Name == "_http._tcp.demo.svc.cluster-b.local." {
+			time.Sleep(time.Second)
+		}
+		return content.ServeDNS(ctx, w, r)
+	})
+	stale, _ := NewStaleStore(time.Hour, 5)
+	gatherPlugin := &GatherSrv{
+		Next:    next,
+		Domain:  "distro.local.",
+		Timeout: 50 * time.Millisecond,
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		Stale: stale,
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.Len(t, msg.Answer, 2)
+	require.Nil(t, msg.IsEdns0())
+
+	failing.Store(true)
+	msg = CheckAssertion(t, gatherPlugin, assertion)
+	require.ElementsMatch(
+		t,
+		[]dns.RR{
+			test.SRV("_http._tcp.demo.svc.distro.local. 30 IN SRV 0 50 8080 a-demo-0.svc.distro.local."),
+			test.SRV("_http._tcp.demo.svc.distro.local. 5 IN SRV 0 50 8080 b-demo-0.svc.distro.local."),
+		},
+		msg.Answer,
+	)
+	require.Equal(t, []dns.RR{test.A("b-demo-0.svc.distro.local. 5 IN A 10.9.1.2")}, msg.Extra[:1])
+	extendedError, ok := msg.IsEdns0().Option[0].(*dns.EDNS0_EDE)
+	require.True(t, ok)
+	require.Equal(t, dns.ExtendedErrorCodeStaleAnswer, extendedError.InfoCode)
+}
@@ -0,0 +1,36 @@
+package gathersrv
+
+import (
+	"github.com/miekg/dns"
+	"sort"
+)
+
+// svcbOf returns SVCB data of SVCB and HTTPS records
+func svcbOf(rr dns.RR) *dns.SVCB {
+	switch record := rr.(type) {
+	case *dns.SVCB:
+		return record
+	case *dns.HTTPS:
+		return &record.SVCB
+	}
+	return nil
+}
+
+// sortServiceBindings stably sorts SVCB and HTTPS records merged from clusters by their priority (AliasMode records first),
+// the records keep positions occupied by them in the answer, so records of other types are not moved
+func sortServiceBindings(answer []dns.RR) {
+	var positions []int
+	var bindings []dns.RR
+	for i, rr := range answer {
+		if svcbOf(rr) != nil {
+			positions = append(positions, i)
+			bindings = append(bindings, rr)
+		}
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return svcbOf(bindings[i]).Priority < svcbOf(bindings[j]).Priority
+	})
+	for i, position := range positions {
+		answer[position] = bindings[i]
+	}
+}
@@ -0,0 +1,80 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestShouldTranslateServiceBindingsFromClusters(t *testing.T) {
+	for _, rrtype := range []string{"SVCB", "HTTPS"} {
+		assertion := Assertion{
+			GivenName:     "_8443._https.demo.svc.distro.local.",
+			GivenType:     dns.StringToType[rrtype],
+			ExpectedRcode: dns.RcodeSuccess,
+			ExpectedError: nil,
+		}
+		record := func(s string) dns.RR {
+			rr, err := dns.NewRR(s)
+			require.NoError(t, err)
+			return rr
+		}
+		gatherPlugin := &GatherSrv{
+			Next: PrepareDelayedNextHandler(
+				PrepareContentNextHandler(
+					map[string]Assertion{
+						"_8443._https.demo.svc.cluster-a.local.": assertion,
+						"_8443._https.demo.svc.cluster-b.local.": assertion,
+					},
+					map[string][]dns.RR{
+						"_8443._https.demo.svc.cluster-a.local.": {
+							record("_8443._https.demo.svc.cluster-a.local. 30 IN " + rrtype + " 2 demo-0.svc.cluster-a.local. alpn=h2 ipv4hint=10.8.1.2"),
+						},
+						"_8443._https.demo.svc.cluster-b.local.": {
+							record("_8443._https.demo.svc.cluster-b.local. 30 IN " + rrtype + " 1 demo-0.svc.cluster-b.local. alpn=h2 ipv4hint=10.9.1.2"),
+							record("_8443._https.demo.svc.cluster-b.local. 30 IN " + rrtype + " 3 cdn.example.com. ipv6hint=2001:db8::1"),
+						},
+					},
+					map[string][]dns.RR{
+						"_8443._https.demo.svc.cluster-a.local.": {test.A("demo-0.svc.cluster-a.local. 30 IN A 10.8.1.2")},
+					},
+				),
+				map[string]time.Duration{"_8443._https.demo.svc.cluster-b.local.": 20 * time.Millisecond},
+			),
+			Domain: "distro.local.",
+			Clusters: []Cluster{
+				{Suffix: "cluster-a.local.", Prefix: "a-"},
+				{Suffix: "cluster-b.local.", Prefix: "b-"},
+			},
+		}
+		msg := CheckAssertion(t, gatherPlugin, assertion)
+		require.Equal(
+			t,
+			[]string{
+				"_8443._https.demo.svc.distro.local.\t30\tIN\t" + rrtype + "\t1 b-demo-0.svc.distro.local. alpn=\"h2\" ipv4hint=\"10.9.1.2\"",
+				"_8443._https.demo.svc.distro.local.\t30\tIN\t" + rrtype + "\t2 a-demo-0.svc.distro.local. alpn=\"h2\" ipv4hint=\"10.8.1.2\"",
+				"_8443._https.demo.svc.distro.local.\t30\tIN\t" + rrtype + "\t3 cdn.example.com. ipv6hint=\"2001:db8::1\"",
+			},
+			RecordsAsStrings(msg.Answer),
+		)
+		require.Equal(t, []string{"a-demo-0.svc.distro.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(msg.Extra))
+	}
+}
+
+func TestShouldKeepPositionsOfOtherRecordsWhileSortingServiceBindings(t *testing.T) {
+	answer := []dns.RR{
+		test.CNAME("demo.svc.distro.local. 30 IN CNAME web.svc.distro.local."),
+		&dns.HTTPS{SVCB: dns.SVCB{Hdr: dns.RR_Header{Name: "web.svc.distro.local.", Rrtype: dns.TypeHTTPS}, Priority: 2, Target: "a."}},
+		&dns.HTTPS{SVCB: dns.SVCB{Hdr: dns.RR_Header{Name: "web.svc.distro.local.", Rrtype: dns.TypeHTTPS}, Priority: 1, Target: "b."}},
+		&dns.HTTPS{SVCB: dns.SVCB{Hdr: dns.RR_Header{Name: "web.svc.distro.local.", Rrtype: dns.TypeHTTPS}, Priority: 2, Target: "c."}},
+	}
+	sortServiceBindings(answer)
+	require.Equal(t, dns.TypeCNAME, answer[0].Header().Rrtype)
+	var targets []string
+	for _, rr := range answer[1:] {
+		targets = append(targets, svcbOf(rr).Target)
+	}
+	require.Equal(t, []string{"b.", "a.", "c."}, targets)
+}
@@ -0,0 +1,44 @@
+package gathersrv
+
+import (
+	"errors"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"net"
+)
+
+var errSubResponseTruncated = errors.New("sub-response truncated")
+
+// tcpResponseWriter presents the client connection as TCP one, so the next plugin (e.g. forward) repeats the query over TCP
+type tcpResponseWriter struct {
+	dns.ResponseWriter
+}
+
+func (w tcpResponseWriter) RemoteAddr() net.Addr {
+	return asTCPAddr(w.ResponseWriter.RemoteAddr())
+}
+
+func (w tcpResponseWriter) LocalAddr() net.Addr {
+	return asTCPAddr(w.ResponseWriter.LocalAddr())
+}
+
+func asTCPAddr(addr net.Addr) net.Addr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return &net.TCPAddr{IP: udp.IP, Port: udp.Port, Zone: udp.Zone}
+	}
+	return addr
+}
+
+// truncated reports whether the sub-response has been cut and could not be merged as it is
+func truncated(resp *NextResp) bool {
+	return resp.Err == nil && resp.Msg != nil && resp.Msg.Truncated
+}
+
+// overTCP returns the writer used to repeat the truncated sub-request, false if the client connection is already TCP
+func overTCP(w dns.ResponseWriter) (dns.ResponseWriter, bool) {
+	state := request.Request{W: w}
+	if state.Proto() == "tcp" {
+		return w, false
+	}
+	return tcpResponseWriter{w}, true
+}
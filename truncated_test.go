@@ -0,0 +1,70 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// PrepareTruncatingNextHandler answers with truncated response over UDP, over TCP only if the cluster supports it
+func PrepareTruncatingNextHandler(tcpClusters map[string]bool, protocols *[]string) test.Handler {
+	var mu sync.Mutex
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		state := request.Request{W: w, Req: r}
+		mu.Lock()
+		*protocols = append(*protocols, state.Proto())
+		mu.Unlock()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A(r.Question[0].Name + " 30 IN A 10.8.1.2")}
+		if state.Proto() == "udp" || !tcpClusters[r.Question[0].Name] {
+			m.Truncated = true
+		}
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestShouldRequeryTruncatedSubResponseOverTcpViaNextPlugin(t *testing.T) {
+	var protocols []string
+	gatherPlugin := &GatherSrv{
+		Next:     PrepareTruncatingNextHandler(map[string]bool{"demo.svc.cluster-a.local.": true}, &protocols),
+		Domain:   "distro.local.",
+		Clusters: []Cluster{{Suffix: "cluster-a.local.", Prefix: "a-"}},
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	_, err := gatherPlugin.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA))
+	require.NoError(t, err)
+	require.Equal(t, []string{"udp", "tcp"}, protocols)
+	require.False(t, rec.Msg.Truncated)
+	require.Equal(t, []string{"a-demo.svc.distro.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+}
+
+func TestShouldNotMergeTruncatedSubResponses(t *testing.T) {
+	var protocols []string
+	retry, _ := NewRetryPolicy(2, time.Millisecond, nil)
+	gatherPlugin := &GatherSrv{
+		Next:   PrepareTruncatingNextHandler(map[string]bool{"demo.svc.cluster-b.local.": true}, &protocols),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", Retry: retry},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	_, err := gatherPlugin.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("demo.svc.distro.local.", dns.TypeA))
+	require.NoError(t, err)
+	require.Len(t, protocols, 6, "Expected the truncated sub-request to be retried")
+	require.False(t, rec.Msg.Truncated)
+	require.Equal(t, []string{"b-demo.svc.distro.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+}
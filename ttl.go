@@ -0,0 +1,73 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+)
+
+const (
+	ttlModeKeep  = "keep"
+	ttlModeMin   = "min"
+	ttlModeClamp = "clamp"
+)
+
+// TTLPolicy normalizes TTL of records in the merged response:
+// keep - TTLs returned by clusters are kept, min - every record gets the minimum TTL of the merged set,
+// clamp - TTLs are bounded by the range. Independently, TTLs of partial responses (some clusters missing) are capped.
+type TTLPolicy struct {
+	mode    string
+	min     uint32
+	max     uint32
+	partial uint32
+}
+
+// NewTTLPolicy returns TTLPolicy, lower and upper bounds are used only by clamp mode, partial equal to zero disables capping of partial responses.
+func NewTTLPolicy(mode string, lower, upper, partial uint32) (*TTLPolicy, error) {
+	switch mode {
+	case ttlModeKeep, ttlModeMin:
+	case ttlModeClamp:
+		if lower > upper {
+			return nil, fmt.Errorf("incorrect ttl range <%d-%d>", lower, upper)
+		}
+	default:
+		return nil, fmt.Errorf("incorrect ttl mode <%s>", mode)
+	}
+	return &TTLPolicy{mode: mode, min: lower, max: upper, partial: partial}, nil
+}
+
+// Apply adjusts TTL of answer and additional records of the merged response.
+func (tp *TTLPolicy) Apply(response *dns.Msg, partial bool) {
+	records := make([]dns.RR, 0, len(response.Answer)+len(response.Extra))
+	for _, section := range [][]dns.RR{response.Answer, response.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				records = append(records, rr)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return
+	}
+	switch tp.mode {
+	case ttlModeMin:
+		setTTL(records, minTTL(records[0].Header().Ttl, records))
+	case ttlModeClamp:
+		for _, rr := range records {
+			rr.Header().Ttl = max(tp.min, min(tp.max, rr.Header().Ttl))
+		}
+	}
+	if partial && tp.partial > 0 {
+		for _, rr := range records {
+			rr.Header().Ttl = min(tp.partial, rr.Header().Ttl)
+		}
+	}
+}
+
+// setTTL overrides TTL of all records except OPT
+func setTTL(records []dns.RR, ttl uint32) {
+	for _, rr := range records {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			rr.Header().Ttl = ttl
+		}
+	}
+}
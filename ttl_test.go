@@ -0,0 +1,112 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestShouldApplyTTLPolicy(t *testing.T) {
+	prepareResponse := func() *dns.Msg {
+		response := &dns.Msg{
+			Answer: []dns.RR{
+				test.SRV("_http._tcp.demo.svc.distro.local. 5 IN SRV 0 50 8080 a-demo-0.svc.distro.local."),
+				test.SRV("_http._tcp.demo.svc.distro.local. 120 IN SRV 0 50 8080 b-demo-0.svc.distro.local."),
+			},
+			Extra: []dns.RR{test.A("a-demo-0.svc.distro.local. 30 IN A 10.8.1.2")},
+		}
+		response.SetEdns0(4096, false)
+		return response
+	}
+	ttls := func(response *dns.Msg) (result []uint32) {
+		for _, rr := range append(append([]dns.RR{}, response.Answer...), response.Extra...) {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				result = append(result, rr.Header().Ttl)
+			}
+		}
+		return
+	}
+	expectations := []struct {
+		policy   *TTLPolicy
+		partial  bool
+		expected []uint32
+	}{
+		{&TTLPolicy{mode: ttlModeKeep}, false, []uint32{5, 120, 30}},
+		{&TTLPolicy{mode: ttlModeMin}, false, []uint32{5, 5, 5}},
+		{&TTLPolicy{mode: ttlModeClamp, min: 10, max: 60}, false, []uint32{10, 60, 30}},
+		{&TTLPolicy{mode: ttlModeKeep, partial: 20}, false, []uint32{5, 120, 30}},
+		{&TTLPolicy{mode: ttlModeKeep, partial: 20}, true, []uint32{5, 20, 20}},
+		{&TTLPolicy{mode: ttlModeClamp, min: 10, max: 60, partial: 20}, true, []uint32{10, 20, 20}},
+	}
+	for _, expectation := range expectations {
+		response := prepareResponse()
+		expectation.policy.Apply(response, expectation.partial)
+		require.Equalf(t, expectation.expected, ttls(response), "Unexpected TTLs for: %v", expectation)
+		require.NotNil(t, response.IsEdns0())
+	}
+}
+
+func TestShouldFailIfTTLPolicyIsIncorrect(t *testing.T) {
+	_, err := NewTTLPolicy("max", 0, 0, 0)
+	require.Error(t, err)
+	_, err = NewTTLPolicy(ttlModeClamp, 60, 10, 0)
+	require.Error(t, err)
+}
+
+func TestShouldReduceTTLOfPartialResponseAndOverrideTTLOfCluster(t *testing.T) {
+	assertion := Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	}
+	answers := map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}
+	policy, _ := NewTTLPolicy(ttlModeKeep, 0, 0, 5)
+
+	gatherPlugin := &GatherSrv{
+		Next: PrepareContentNextHandler(
+			map[string]Assertion{
+				"_http._tcp.demo.svc.cluster-a.local.": assertion,
+				"_http._tcp.demo.svc.cluster-b.local.": assertion,
+			},
+			answers,
+			map[string][]dns.RR{},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", TTL: 10},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		TTL: policy,
+	}
+	msg := CheckAssertion(t, gatherPlugin, assertion)
+	require.ElementsMatch(
+		t,
+		[]string{
+			"_http._tcp.demo.svc.distro.local.\t10\tIN\tSRV\t0 50 8080 a-demo-0.svc.distro.local.",
+			"_http._tcp.demo.svc.distro.local.\t30\tIN\tSRV\t0 50 8080 b-demo-0.svc.distro.local.",
+		},
+		RecordsAsStrings(msg.Answer),
+	)
+
+	// cluster-b fails, so the response is partial
+	gatherPlugin.Next = PrepareContentNextHandler(
+		map[string]Assertion{"_http._tcp.demo.svc.cluster-a.local.": assertion},
+		answers,
+		map[string][]dns.RR{},
+	)
+	msg = CheckAssertion(t, gatherPlugin, assertion)
+	require.Equal(
+		t,
+		[]string{"_http._tcp.demo.svc.distro.local.\t5\tIN\tSRV\t0 50 8080 a-demo-0.svc.distro.local."},
+		RecordsAsStrings(msg.Answer),
+	)
+}
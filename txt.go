@@ -0,0 +1,139 @@
+package gathersrv
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	txtModeAll       = "all"
+	txtModePrimary   = "primary"
+	txtModeFirst     = "first"
+	txtModeConsensus = "consensus"
+	txtModeConcat    = "concat"
+
+	// txtOptionSeparator joins option strings of clusters like parameters of the connection string
+	txtOptionSeparator       = "&"
+	maxCharacterStringLength = 255
+)
+
+// TXTPolicy decides which TXT records of clusters are placed in the merged response.
+// Consumers like mongodb+srv accept at most one TXT record, while records of all clusters are merged by default:
+// all - records of all clusters, primary - records of the designated cluster (or of the first responder if it has not answered),
+// first - records of the first cluster which has answered with TXT records, consensus - records if all clusters returned the same ones,
+// otherwise SERVFAIL, concat - a single record with option strings of all distinct records joined with "&".
+type TXTPolicy struct {
+	mode    string
+	primary string
+}
+
+// NewTXTPolicy returns TXTPolicy, primary is the prefix of the designated cluster used only by primary mode.
+func NewTXTPolicy(mode, primary string) (*TXTPolicy, error) {
+	switch mode {
+	case txtModeAll, txtModeFirst, txtModeConsensus, txtModeConcat:
+		if primary != "" {
+			return nil, fmt.Errorf("primary cluster could not be defined for txt mode <%s>", mode)
+		}
+	case txtModePrimary:
+		if primary == "" {
+			return nil, fmt.Errorf("txt mode <%s> requires the prefix of primary cluster", mode)
+		}
+	default:
+		return nil, fmt.Errorf("incorrect txt mode <%s>", mode)
+	}
+	return &TXTPolicy{mode: mode, primary: primary}, nil
+}
+
+// gathered reports whether TXT records are gathered per cluster instead of being merged directly
+func (tp *TXTPolicy) gathered() bool {
+	return tp != nil && tp.mode != txtModeAll
+}
+
+// apply places TXT records selected from records of clusters (listed in order of arrival) in the merged response
+func (tp *TXTPolicy) apply(response *dns.Msg, order []string, records map[string][]dns.RR) {
+	if len(order) == 0 {
+		return
+	}
+	switch tp.mode {
+	case txtModePrimary:
+		if _, ok := records[tp.primary]; ok {
+			response.Answer = append(response.Answer, records[tp.primary]...)
+			return
+		}
+		response.Answer = append(response.Answer, firstTXT(order, records)...)
+	case txtModeFirst:
+		response.Answer = append(response.Answer, firstTXT(order, records)...)
+	case txtModeConsensus:
+		expected := txtFingerprint(records[order[0]])
+		for _, prefix := range order[1:] {
+			if txtFingerprint(records[prefix]) != expected {
+				response.Rcode = dns.RcodeServerFailure
+				response.Answer = []dns.RR{}
+				response.Extra = []dns.RR{}
+				addExtendedError(
+					response,
+					dns.ExtendedErrorCodeOther,
+					fmt.Sprintf("Conflicting TXT records of clusters: %s", strings.Join(order, ", ")),
+				)
+				return
+			}
+		}
+		response.Answer = append(response.Answer, records[order[0]]...)
+	case txtModeConcat:
+		var concatenated *dns.TXT
+		var options []string
+		for _, prefix := range order {
+			for _, rr := range records[prefix] {
+				txt := rr.(*dns.TXT)
+				// clients join character-strings of the record, so each record is a single option string
+				option := strings.Join(txt.Txt, "")
+				if option == "" || slices.Contains(options, option) {
+					continue
+				}
+				options = append(options, option)
+				if concatenated == nil {
+					concatenated = dns.Copy(txt).(*dns.TXT)
+					continue
+				}
+				concatenated.Hdr.Ttl = min(concatenated.Hdr.Ttl, txt.Hdr.Ttl)
+			}
+		}
+		if concatenated != nil {
+			concatenated.Txt = splitCharacterStrings(strings.Join(options, txtOptionSeparator))
+			response.Answer = append(response.Answer, concatenated)
+		}
+	}
+}
+
+// splitCharacterStrings divides the text into character-strings which could not be longer than 255 octets
+func splitCharacterStrings(text string) []string {
+	var parts []string
+	for len(text) > maxCharacterStringLength {
+		parts = append(parts, text[:maxCharacterStringLength])
+		text = text[maxCharacterStringLength:]
+	}
+	return append(parts, text)
+}
+
+// firstTXT returns records of the first cluster which has returned any TXT record
+func firstTXT(order []string, records map[string][]dns.RR) []dns.RR {
+	for _, prefix := range order {
+		if len(records[prefix]) > 0 {
+			return records[prefix]
+		}
+	}
+	return nil
+}
+
+// txtFingerprint returns the representation of TXT records data independent of their order and TTL
+func txtFingerprint(records []dns.RR) string {
+	data := make([]string, 0, len(records))
+	for _, rr := range records {
+		data = append(data, strings.Join(rr.(*dns.TXT).Txt, "\x00"))
+	}
+	sort.Strings(data)
+	return strings.Join(data, "\n")
+}
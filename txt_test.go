@@ -0,0 +1,128 @@
+package gathersrv
+
+import (
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func PrepareTXTGatherSrv(policy *TXTPolicy, txtA string, txtB string) *GatherSrv {
+	assertion := Assertion{GivenName: "demo.svc.distro.local.", GivenType: dns.TypeTXT, ExpectedRcode: dns.RcodeSuccess}
+	return &GatherSrv{
+		Next: PrepareDelayedNextHandler(
+			PrepareContentNextHandler(
+				map[string]Assertion{
+					"demo.svc.cluster-a.local.": assertion,
+					"demo.svc.cluster-b.local.": assertion,
+				},
+				map[string][]dns.RR{
+					"demo.svc.cluster-a.local.": {test.TXT("demo.svc.cluster-a.local. 30 IN TXT " + txtA)},
+					"demo.svc.cluster-b.local.": {test.TXT("demo.svc.cluster-b.local. 10 IN TXT " + txtB)},
+				},
+				map[string][]dns.RR{},
+			),
+			map[string]time.Duration{"demo.svc.cluster-b.local.": 20 * time.Millisecond},
+		),
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-"},
+			{Suffix: "cluster-b.local.", Prefix: "b-"},
+		},
+		TXT: policy,
+	}
+}
+
+func TestShouldMergeTXTRecordsAccordingToPolicy(t *testing.T) {
+	primary, _ := NewTXTPolicy(txtModePrimary, "b-")
+	first, _ := NewTXTPolicy(txtModeFirst, "")
+	concat, _ := NewTXTPolicy(txtModeConcat, "")
+	all, _ := NewTXTPolicy(txtModeAll, "")
+	expectations := map[*TXTPolicy][]string{
+		nil: {
+			"demo.svc.distro.local.\t30\tIN\tTXT\t\"replicaSet=rs0\"",
+			"demo.svc.distro.local.\t10\tIN\tTXT\t\"authSource=admin\"",
+		},
+		all: {
+			"demo.svc.distro.local.\t30\tIN\tTXT\t\"replicaSet=rs0\"",
+			"demo.svc.distro.local.\t10\tIN\tTXT\t\"authSource=admin\"",
+		},
+		primary: {"demo.svc.distro.local.\t10\tIN\tTXT\t\"authSource=admin\""},
+		first:   {"demo.svc.distro.local.\t30\tIN\tTXT\t\"replicaSet=rs0\""},
+		concat:  {"demo.svc.distro.local.\t10\tIN\tTXT\t\"replicaSet=rs0&authSource=admin\""},
+	}
+	for policy, expected := range expectations {
+		msg := CheckAssertion(t, PrepareTXTGatherSrv(policy, "replicaSet=rs0", "authSource=admin"), Assertion{
+			GivenName:     "demo.svc.distro.local.",
+			GivenType:     dns.TypeTXT,
+			ExpectedRcode: dns.RcodeSuccess,
+		})
+		require.Equalf(t, expected, RecordsAsStrings(msg.Answer), "Unexpected records for policy: %v", policy)
+	}
+}
+
+func TestShouldFallbackToFirstResponderIfPrimaryClusterFailed(t *testing.T) {
+	primary, _ := NewTXTPolicy(txtModePrimary, "c-")
+	gatherPlugin := PrepareTXTGatherSrv(primary, "replicaSet=rs0", "authSource=admin")
+	gatherPlugin.Clusters = append(gatherPlugin.Clusters, Cluster{Suffix: "cluster-c.local.", Prefix: "c-"})
+	msg := CheckAssertion(t, gatherPlugin, Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeTXT,
+		ExpectedRcode: dns.RcodeSuccess,
+	})
+	require.Equal(t, []string{"demo.svc.distro.local.\t30\tIN\tTXT\t\"replicaSet=rs0\""}, RecordsAsStrings(msg.Answer))
+}
+
+func TestShouldRequireConsensusOfTXTRecords(t *testing.T) {
+	consensus, _ := NewTXTPolicy(txtModeConsensus, "")
+	msg := CheckAssertion(t, PrepareTXTGatherSrv(consensus, "replicaSet=rs0", "replicaSet=rs0"), Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeTXT,
+		ExpectedRcode: dns.RcodeSuccess,
+	})
+	require.Equal(t, []string{"demo.svc.distro.local.\t30\tIN\tTXT\t\"replicaSet=rs0\""}, RecordsAsStrings(msg.Answer))
+
+	msg = CheckAssertion(t, PrepareTXTGatherSrv(consensus, "replicaSet=rs0", "replicaSet=rs1"), Assertion{
+		GivenName:     "demo.svc.distro.local.",
+		GivenType:     dns.TypeTXT,
+		ExpectedRcode: dns.RcodeServerFailure,
+	})
+	require.Empty(t, msg.Answer)
+	extendedError, ok := msg.IsEdns0().Option[0].(*dns.EDNS0_EDE)
+	require.True(t, ok)
+	require.Equal(t, dns.ExtendedErrorCodeOther, extendedError.InfoCode)
+	require.Equal(t, "Conflicting TXT records of clusters: a-, b-", extendedError.ExtraText)
+}
+
+func TestShouldFailIfTXTPolicyIsIncorrect(t *testing.T) {
+	for _, params := range [][2]string{{"any", ""}, {txtModePrimary, ""}, {txtModeFirst, "a-"}} {
+		_, err := NewTXTPolicy(params[0], params[1])
+		require.Errorf(t, err, "Expected error for: %v", params)
+	}
+}
+
+func TestShouldConcatTXTRecordsIntoValidOptionString(t *testing.T) {
+	concat, _ := NewTXTPolicy(txtModeConcat, "")
+	for txtB, expected := range map[string]url.Values{
+		`"authSource=admin"`:                    {"replicaSet": {"rs0"}, "authSource": {"admin"}},
+		`"authSource=" "admin"`:                 {"replicaSet": {"rs0"}, "authSource": {"admin"}},
+		`"replicaSet=rs0"`:                      {"replicaSet": {"rs0"}},
+		`"authSource=admin&loadBalanced=false"`: {"replicaSet": {"rs0"}, "authSource": {"admin"}, "loadBalanced": {"false"}},
+	} {
+		msg := CheckAssertion(t, PrepareTXTGatherSrv(concat, "replicaSet=rs0", txtB), Assertion{
+			GivenName:     "demo.svc.distro.local.",
+			GivenType:     dns.TypeTXT,
+			ExpectedRcode: dns.RcodeSuccess,
+		})
+		require.Len(t, msg.Answer, 1)
+		// mongodb drivers join character-strings of the record and parse it like the query of the connection string
+		options, err := url.ParseQuery(strings.Join(msg.Answer[0].(*dns.TXT).Txt, ""))
+		require.NoError(t, err)
+		require.Equalf(t, expected, options, "Unexpected options for: %s", txtB)
+	}
+
+	require.Equal(t, []string{strings.Repeat("a", 255), "b"}, splitCharacterStrings(strings.Repeat("a", 255)+"b"))
+}
@@ -0,0 +1,125 @@
+package gathersrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+const defaultDnsPort = "53"
+
+var errNoUpstreamAddresses = errors.New("no upstream addresses defined")
+
+// failedRcodes lists response codes of servers unable to answer, the next address is tried after them
+var failedRcodes = map[int]bool{dns.RcodeServerFailure: true, dns.RcodeRefused: true}
+
+type upstreamAddress struct {
+	network string
+	address string
+}
+
+func (ua upstreamAddress) String() string {
+	return ua.network + "://" + ua.address
+}
+
+// Upstream resolves sub-requests of a single cluster directly against its DNS servers.
+// Addresses are tried in the configured order - the next one is used only if the previous one failed
+// or answered with SERVFAIL or REFUSED.
+// Truncated UDP responses are re-queried over TCP, so large answers are not silently cut.
+type Upstream struct {
+	addresses []upstreamAddress
+}
+
+// NewUpstream returns Upstream for addresses in form [udp://|tcp://]IP[:PORT].
+func NewUpstream(addresses []string) (*Upstream, error) {
+	if len(addresses) == 0 {
+		return nil, errNoUpstreamAddresses
+	}
+	upstream := &Upstream{}
+	for _, raw := range addresses {
+		address, err := parseUpstreamAddress(raw)
+		if err != nil {
+			return nil, err
+		}
+		upstream.addresses = append(upstream.addresses, address)
+	}
+	return upstream, nil
+}
+
+// ServeDNS implements the plugin.Handler interface, so upstream can be used interchangeably with the next plugin.
+func (u *Upstream) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	var lastErr error
+	var fallback *dns.Msg
+	for _, address := range u.addresses {
+		client := &dns.Client{Net: address.network}
+		res, _, err := client.ExchangeContext(ctx, r, address.address)
+		if err == nil && res.Truncated && address.network == "udp" {
+			client.Net = "tcp"
+			res, _, err = client.ExchangeContext(ctx, r, address.address)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("upstream %s: %w", address, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if len(res.Question) == 0 {
+			// servers may omit the question in replies like FORMERR, while merging relies on it
+			res.Question = r.Question
+		}
+		if failedRcodes[res.Rcode] {
+			// the server is not able to answer, the last of such responses is used if no other server answers
+			fallback = res
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		return writeUpstreamResponse(w, res)
+	}
+	if fallback != nil {
+		return writeUpstreamResponse(w, fallback)
+	}
+	return dns.RcodeServerFailure, lastErr
+}
+
+func writeUpstreamResponse(w dns.ResponseWriter, res *dns.Msg) (int, error) {
+	if err := w.WriteMsg(res); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// alternate returns Upstream which starts with the next address, so a hedged sub-request hits another server first
+func (u *Upstream) alternate() *Upstream {
+	if len(u.addresses) < 2 {
+		return u
+	}
+	return &Upstream{addresses: append(append([]upstreamAddress{}, u.addresses[1:]...), u.addresses[0])}
+}
+
+// Name implements the Handler interface.
+func (u *Upstream) Name() string { return gatherSrvPluginName }
+
+func parseUpstreamAddress(raw string) (upstreamAddress, error) {
+	address := upstreamAddress{network: "udp", address: raw}
+	for _, network := range []string{"udp", "tcp"} {
+		if strings.HasPrefix(raw, network+"://") {
+			address.network = network
+			address.address = strings.TrimPrefix(raw, network+"://")
+		}
+	}
+	host, port, err := net.SplitHostPort(address.address)
+	if err != nil {
+		host, port = address.address, defaultDnsPort
+	}
+	if net.ParseIP(host) == nil {
+		return address, fmt.Errorf("Provided incorrect upstream address <%s>", raw)
+	}
+	address.address = net.JoinHostPort(host, port)
+	return address, nil
+}
@@ -0,0 +1,256 @@
+package gathersrv
+
+import (
+	"context"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestShouldParseUpstreamAddresses(t *testing.T) {
+	expectations := map[string]upstreamAddress{
+		"10.8.0.1":            {"udp", "10.8.0.1:53"},
+		"10.8.0.1:5300":       {"udp", "10.8.0.1:5300"},
+		"udp://10.8.0.1":      {"udp", "10.8.0.1:53"},
+		"tcp://10.8.0.1:5300": {"tcp", "10.8.0.1:5300"},
+		"[fd00::1]:5300":      {"udp", "[fd00::1]:5300"},
+		"fd00::1":             {"udp", "[fd00::1]:53"},
+	}
+	for raw, expected := range expectations {
+		address, err := parseUpstreamAddress(raw)
+		require.NoError(t, err)
+		require.Equal(t, expected, address)
+	}
+
+	for _, raw := range []string{"dns.cluster-a.local", "tls://10.8.0.1", ""} {
+		_, err := parseUpstreamAddress(raw)
+		require.Errorf(t, err, "Expected error for address <%s>", raw)
+	}
+}
+
+func TestShouldFailoverToNextUpstreamAddress(t *testing.T) {
+	server := dnstest.NewServer(PrepareClusterDnsHandler(map[string][]dns.RR{
+		"demo-0.svc.cluster-a.local.": {test.A("demo-0.svc.cluster-a.local. 30 IN A 10.8.1.2")},
+	}))
+	defer server.Close()
+
+	for _, network := range []string{"udp", "tcp"} {
+		upstream, err := NewUpstream([]string{network + "://127.0.0.1:1", network + "://" + server.Addr})
+		require.NoError(t, err)
+
+		req := new(dns.Msg)
+		req.SetQuestion("demo-0.svc.cluster-a.local.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := upstream.ServeDNS(context.TODO(), rec, req)
+
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, code)
+		require.Equal(t, []string{"demo-0.svc.cluster-a.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+	}
+}
+
+func TestShouldReturnErrorIfAllUpstreamAddressesFailed(t *testing.T) {
+	upstream, err := NewUpstream([]string{"127.0.0.1:1", "tcp://127.0.0.1:1"})
+	require.NoError(t, err)
+
+	req := new(dns.Msg)
+	req.SetQuestion("demo-0.svc.cluster-a.local.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	code, err := upstream.ServeDNS(context.TODO(), rec, req)
+
+	require.Error(t, err)
+	require.Equal(t, dns.RcodeServerFailure, code)
+	require.Nil(t, rec.Msg)
+}
+
+func TestShouldGatherResponsesFromClusterUpstreams(t *testing.T) {
+	server := dnstest.NewServer(PrepareClusterDnsHandler(map[string][]dns.RR{
+		"_http._tcp.demo.svc.cluster-a.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-a.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-a.local."),
+		},
+		"_http._tcp.demo.svc.cluster-b.local.": {
+			test.SRV("_http._tcp.demo.svc.cluster-b.local. 30 IN SRV 0 50 8080 demo-0.svc.cluster-b.local."),
+		},
+	}))
+	defer server.Close()
+
+	upstream, err := NewUpstream([]string{server.Addr})
+	require.NoError(t, err)
+	gatherPlugin := &GatherSrv{
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", Upstream: upstream},
+			{Suffix: "cluster-b.local.", Prefix: "b-", Upstream: upstream},
+		},
+	}
+
+	msg := CheckAssertion(t, gatherPlugin, Assertion{
+		GivenName:     "_http._tcp.demo.svc.distro.local.",
+		GivenType:     dns.TypeSRV,
+		ExpectedRcode: dns.RcodeSuccess,
+		ExpectedError: nil,
+	})
+	require.ElementsMatch(
+		t,
+		[]string{
+			"_http._tcp.demo.svc.distro.local.\t30\tIN\tSRV\t0 50 8080 a-demo-0.svc.distro.local.",
+			"_http._tcp.demo.svc.distro.local.\t30\tIN\tSRV\t0 50 8080 b-demo-0.svc.distro.local.",
+		},
+		RecordsAsStrings(msg.Answer),
+	)
+}
+
+func TestShouldRequeryOverTcpIfUdpResponseIsTruncated(t *testing.T) {
+	server := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if w.RemoteAddr().Network() == "udp" {
+			m.Truncated = true
+		} else {
+			m.Answer = []dns.RR{test.A("demo-0.svc.cluster-a.local. 30 IN A 10.8.1.2")}
+		}
+		_ = w.WriteMsg(m)
+	})
+	defer server.Close()
+
+	upstream, err := NewUpstream([]string{server.Addr})
+	require.NoError(t, err)
+
+	req := new(dns.Msg)
+	req.SetQuestion("demo-0.svc.cluster-a.local.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = upstream.ServeDNS(context.TODO(), rec, req)
+
+	require.NoError(t, err)
+	require.False(t, rec.Msg.Truncated)
+	require.Equal(t, []string{"demo-0.svc.cluster-a.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+}
+
+func TestShouldStartAlternateUpstreamFromNextAddress(t *testing.T) {
+	upstream, err := NewUpstream([]string{"10.8.0.1", "10.8.0.2", "10.8.0.3"})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]upstreamAddress{{"udp", "10.8.0.2:53"}, {"udp", "10.8.0.3:53"}, {"udp", "10.8.0.1:53"}},
+		upstream.alternate().addresses,
+	)
+
+	single, err := NewUpstream([]string{"10.8.0.1"})
+	require.NoError(t, err)
+	require.Same(t, single, single.alternate())
+}
+
+func PrepareClusterDnsHandler(answers map[string][]dns.RR) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if answer, ok := answers[r.Question[0].Name]; ok {
+			m.Answer = answer
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(m)
+	}
+}
+
+func RecordsAsStrings(records []dns.RR) (result []string) {
+	for _, record := range records {
+		result = append(result, record.String())
+	}
+	return
+}
+
+// StartRcodeServer starts UDP server answering all questions with given rcode, unlike dnstest.NewServer
+// its handler is not registered globally, so it could run along with other servers
+func StartRcodeServer(t *testing.T, rcode int) string {
+	return StartUdpServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		_ = w.WriteMsg(m)
+	})
+}
+
+// StartUdpServer starts the server with its own handler, servers of dnstest share the handler registered globally
+func StartUdpServer(t *testing.T, handler dns.HandlerFunc) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        conn,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestShouldFailoverToNextUpstreamAddressOnFailureRcodes(t *testing.T) {
+	server := dnstest.NewServer(PrepareClusterDnsHandler(map[string][]dns.RR{
+		"demo-0.svc.cluster-a.local.": {test.A("demo-0.svc.cluster-a.local. 30 IN A 10.8.1.2")},
+	}))
+	defer server.Close()
+
+	for _, rcode := range []int{dns.RcodeServerFailure, dns.RcodeRefused} {
+		failing := StartRcodeServer(t, rcode)
+		upstream, err := NewUpstream([]string{failing, server.Addr})
+		require.NoError(t, err)
+		req := new(dns.Msg)
+		req.SetQuestion("demo-0.svc.cluster-a.local.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err = upstream.ServeDNS(context.TODO(), rec, req)
+
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+		require.Equal(t, []string{"demo-0.svc.cluster-a.local.\t30\tIN\tA\t10.8.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+
+		// the last failure is returned if no other server answers
+		upstream, err = NewUpstream([]string{failing, "127.0.0.1:1"})
+		require.NoError(t, err)
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err = upstream.ServeDNS(context.TODO(), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, rcode, rec.Msg.Rcode)
+	}
+}
+
+func TestShouldGatherResponsesOfUpstreamsOmittingQuestion(t *testing.T) {
+	server := dnstest.NewServer(PrepareClusterDnsHandler(map[string][]dns.RR{
+		"demo-0.svc.cluster-b.local.": {test.A("demo-0.svc.cluster-b.local. 30 IN A 10.9.1.2")},
+	}))
+	defer server.Close()
+	questionless := StartUdpServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeFormatError)
+		m.Question = nil
+		_ = w.WriteMsg(m)
+	})
+	upstreamA, err := NewUpstream([]string{questionless})
+	require.NoError(t, err)
+	upstreamB, err := NewUpstream([]string{server.Addr})
+	require.NoError(t, err)
+	gatherPlugin := &GatherSrv{
+		Domain: "distro.local.",
+		Clusters: []Cluster{
+			{Suffix: "cluster-a.local.", Prefix: "a-", Upstream: upstreamA},
+			{Suffix: "cluster-b.local.", Prefix: "b-", Upstream: upstreamB},
+		},
+	}
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = gatherPlugin.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("demo-0.svc.distro.local.", dns.TypeA))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Equal(t, []string{"b-demo-0.svc.distro.local.\t30\tIN\tA\t10.9.1.2"}, RecordsAsStrings(rec.Msg.Answer))
+
+	// the question is also restored in the response of the upstream itself
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = upstreamA.ServeDNS(context.TODO(), rec, new(dns.Msg).SetQuestion("demo-0.svc.cluster-a.local.", dns.TypeA))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeFormatError, rec.Msg.Rcode)
+	require.Len(t, rec.Msg.Question, 1)
+}